@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// altSvcTimeFormat curl alt-svc缓存文件中的时间格式
+const altSvcTimeFormat = "20060102 15:04:05"
+
+// altSvcEntry 一条Alt-Svc记录，origin的请求可以改用protocol连接到authority，
+// srcProtocol为收到该记录时origin使用的协议
+type altSvcEntry struct {
+	srcProtocol string
+	protocol    string
+	authority   string
+	expires     time.Time
+	persist     bool
+}
+
+// altSvcCache 按origin(host:port)保存Alt-Svc记录，指定--alt-svc时从文件加载并在结束后写回
+type altSvcCache struct {
+	mu      sync.Mutex
+	entries map[string][]altSvcEntry
+	file    string
+}
+
+// parseAltSvc 解析Alt-Svc响应头，返回nil, true表示clear
+func parseAltSvc(value string, now time.Time) ([]altSvcEntry, bool) {
+	value = strings.TrimSpace(value)
+	if value == "clear" {
+		return nil, true
+	}
+	var entries []altSvcEntry
+	for _, item := range splitQuoted(value, ',') {
+		params := splitQuoted(item, ';')
+		protocol, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok {
+			continue
+		}
+		entry := altSvcEntry{
+			protocol:  strings.TrimSpace(protocol),
+			authority: strings.Trim(strings.TrimSpace(authority), `"`),
+			// RFC 7838 默认有效期为24小时
+			expires: now.Add(24 * time.Hour),
+		}
+		for _, param := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			v = strings.Trim(strings.TrimSpace(v), `"`)
+			switch strings.ToLower(strings.TrimSpace(k)) {
+			case "ma":
+				if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+					entry.expires = now.Add(time.Duration(secs) * time.Second)
+				}
+			case "persist":
+				entry.persist = v == "1"
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, false
+}
+
+// splitQuoted 按sep分割，忽略引号中的sep
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// loadAltSvcCache 创建Alt-Svc缓存，--alt-svc文件不存在时只输出警告
+func loadAltSvcCache() *altSvcCache {
+	cache := &altSvcCache{entries: map[string][]altSvcEntry{}, file: curlFlag.AltSvc}
+	if cache.file == "" {
+		return cache
+	}
+	f, err := os.Open(cache.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("load alt-svc cache error: ", err)
+		}
+		return cache
+	}
+	defer f.Close()
+
+	// 与curl格式一致: ALPN host port ALPN host port "YYYYMMDD HH:MM:SS" persist priority
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 9 {
+			log.Debugf("skip invalid alt-svc line: %s", line)
+			continue
+		}
+		expires, err := time.Parse(altSvcTimeFormat, strings.Trim(fields[6]+" "+fields[7], `"`))
+		if err != nil || time.Now().After(expires) {
+			continue
+		}
+		origin := net.JoinHostPort(fields[1], fields[2])
+		cache.entries[origin] = append(cache.entries[origin], altSvcEntry{
+			srcProtocol: fields[0],
+			protocol:    fields[3],
+			authority:   net.JoinHostPort(fields[4], fields[5]),
+			expires:     expires,
+			persist:     fields[8] == "1",
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warn("read alt-svc cache error: ", err)
+	}
+	return cache
+}
+
+// save 将未过期的记录写回--alt-svc文件
+func (c *altSvcCache) save() {
+	if c.file == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString("# Your alt-svc cache. https://curl.se/docs/alt-svc.html\n")
+	sb.WriteString("# This file was generated by curl-go! Edit at your own risk.\n")
+	now := time.Now()
+	for origin, entries := range c.entries {
+		host, port, _ := net.SplitHostPort(origin)
+		for _, e := range entries {
+			if now.After(e.expires) {
+				continue
+			}
+			altHost, altPort, _ := net.SplitHostPort(e.authority)
+			persist := 0
+			if e.persist {
+				persist = 1
+			}
+			fmt.Fprintf(&sb, "%s %s %s %s %s %s \"%s\" %d 0\n",
+				e.srcProtocol, host, port, e.protocol, altHost, altPort, e.expires.UTC().Format(altSvcTimeFormat), persist)
+		}
+	}
+	if err := os.WriteFile(c.file, []byte(sb.String()), 0644); err != nil {
+		log.Error("save alt-svc cache error: ", err)
+	}
+}
+
+// update 根据响应的Alt-Svc更新origin的记录，alternative authority的host为空时使用origin的host
+func (c *altSvcCache) update(origin string, resp *http.Response) {
+	values := resp.Header.Values("Alt-Svc")
+	if len(values) == 0 {
+		return
+	}
+	// 与curl一致，alt-svc文件中的ALPN为h1、h2或h3
+	srcProtocol := "h" + strconv.Itoa(resp.ProtoMajor)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	host, _, _ := net.SplitHostPort(origin)
+	var entries []altSvcEntry
+	for _, value := range values {
+		parsed, clear := parseAltSvc(value, time.Now())
+		if clear {
+			log.Debugf("alt-svc: clear %s", origin)
+			delete(c.entries, origin)
+			return
+		}
+		for _, e := range parsed {
+			altHost, altPort, err := net.SplitHostPort(e.authority)
+			if err != nil {
+				continue
+			}
+			if altHost == "" {
+				altHost = host
+			}
+			e.authority = net.JoinHostPort(altHost, altPort)
+			e.srcProtocol = srcProtocol
+			log.Debugf("alt-svc: %s can use %s at %s", origin, e.protocol, e.authority)
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > 0 {
+		c.entries[origin] = entries
+	}
+}
+
+// lookup 返回origin可用的HTTP/3 alternative authority
+func (c *altSvcCache) lookup(origin string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, e := range c.entries[origin] {
+		if e.protocol == "h3" && now.Before(e.expires) {
+			return e.authority, true
+		}
+	}
+	return "", false
+}
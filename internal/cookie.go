@@ -0,0 +1,353 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
+)
+
+type cookieEntry struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	HostOnly bool
+	Secure   bool
+	HttpOnly bool
+	// 零值表示会话cookie
+	Expires  time.Time
+	creation time.Time
+}
+
+func (e *cookieEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !e.Expires.After(now)
+}
+
+func (e *cookieEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+// CookieJar 实现了http.CookieJar，并支持读写Netscape格式的cookie文件
+type CookieJar struct {
+	mu      sync.Mutex
+	entries map[string]*cookieEntry
+}
+
+func NewCookieJar() *CookieJar {
+	return &CookieJar{entries: make(map[string]*cookieEntry)}
+}
+
+func canonicalCookieHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func cookieDomainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return net.ParseIP(host) == nil && strings.HasSuffix(host, "."+domain)
+}
+
+// isPublicSuffix 如com、co.uk，cookie不能设置在公共后缀上，否则会发送给该后缀下的所有站点
+func isPublicSuffix(domain string) bool {
+	if net.ParseIP(domain) != nil {
+		return false
+	}
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix == domain
+}
+
+func cookiePathMatch(reqPath, cookiePath string) bool {
+	if reqPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || reqPath[len(cookiePath)] == '/'
+}
+
+func defaultCookiePath(reqPath string) string {
+	if reqPath == "" || reqPath[0] != '/' {
+		return "/"
+	}
+	idx := strings.LastIndex(reqPath, "/")
+	if idx == 0 {
+		return "/"
+	}
+	return reqPath[:idx]
+}
+
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	host := canonicalCookieHost(u.Host)
+	for _, c := range cookies {
+		e := &cookieEntry{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			creation: now,
+		}
+
+		// domain
+		domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+		switch {
+		case domain == "" || domain == host && isPublicSuffix(domain):
+			// 与net/http/cookiejar一致，主机本身是公共后缀时只能设置host-only cookie
+			e.Domain, e.HostOnly = host, true
+		case domain == host:
+			e.Domain = host
+		case isPublicSuffix(domain):
+			log.Warnf("skip cookie %s: domain %s is a public suffix", c.Name, c.Domain)
+			continue
+		case net.ParseIP(host) != nil || !cookieDomainMatch(host, domain):
+			log.Warnf("skip cookie %s: domain %s does not match host %s", c.Name, c.Domain, host)
+			continue
+		default:
+			e.Domain = domain
+		}
+
+		// path
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = defaultCookiePath(u.Path)
+		}
+
+		// secure cookie只能由https设置
+		if e.Secure && u.Scheme != "https" {
+			log.Warnf("skip secure cookie %s from insecure origin: %s", c.Name, u.Host)
+			continue
+		}
+
+		// expires
+		switch {
+		case c.MaxAge < 0:
+			e.Expires = time.Unix(0, 0)
+		case c.MaxAge > 0:
+			e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			e.Expires = c.Expires
+		}
+
+		id := e.id()
+		if e.expired(now) {
+			delete(j.entries, id)
+			log.Tracef("remove cookie: %s", id)
+			continue
+		}
+		if old, ok := j.entries[id]; ok {
+			e.creation = old.creation
+		}
+		j.entries[id] = e
+		log.Tracef("set cookie: %s=%s; domain=%s; path=%s", e.Name, e.Value, e.Domain, e.Path)
+	}
+}
+
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	host := canonicalCookieHost(u.Host)
+	reqPath := u.EscapedPath()
+	if reqPath == "" {
+		reqPath = "/"
+	}
+
+	var matched []*cookieEntry
+	for id, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, id)
+			continue
+		}
+		if e.HostOnly && host != e.Domain || !e.HostOnly && !cookieDomainMatch(host, e.Domain) {
+			continue
+		}
+		if !cookiePathMatch(reqPath, e.Path) {
+			continue
+		}
+		if e.Secure && u.Scheme != "https" {
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	// 路径更长的优先，其次是创建时间更早的优先
+	sort.Slice(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+		return matched[a].creation.Before(matched[b].creation)
+	})
+
+	cookies := make([]*http.Cookie, 0, len(matched))
+	for _, e := range matched {
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+	return cookies
+}
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Load 读取Netscape格式的cookie文件
+func (j *CookieJar) Load(r io.Reader) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = line[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// domain includeSubdomains path secure expires name value
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("invalid cookie file line %d: %s", lineNo, line)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cookie expires at line %d: %s", lineNo, fields[4])
+		}
+
+		e := &cookieEntry{
+			Domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			HostOnly: strings.ToUpper(fields[1]) != "TRUE",
+			Path:     fields[2],
+			Secure:   strings.ToUpper(fields[3]) == "TRUE",
+			HttpOnly: httpOnly,
+			Name:     fields[5],
+			Value:    fields[6],
+			creation: now,
+		}
+		if expires != 0 {
+			e.Expires = time.Unix(expires, 0)
+		}
+		if e.expired(now) {
+			continue
+		}
+		j.entries[e.id()] = e
+	}
+	return scanner.Err()
+}
+
+// Save 以Netscape格式写入所有未过期的cookie
+func (j *CookieJar) Save(w io.Writer) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]*cookieEntry, 0, len(j.entries))
+	now := time.Now()
+	for _, e := range j.entries {
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].id() < entries[b].id()
+	})
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n")
+	bw.WriteString("# https://curl.se/docs/http-cookies.html\n")
+	bw.WriteString("# This file was generated by curl-go! Edit at your own risk.\n\n")
+
+	boolStr := func(b bool) string {
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	for _, e := range entries {
+		domain := e.Domain
+		if !e.HostOnly {
+			domain = "." + domain
+		}
+		if e.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, boolStr(!e.HostOnly), e.Path, boolStr(e.Secure), expires, e.Name, e.Value)
+	}
+	return bw.Flush()
+}
+
+// buildCookieJar 根据-b/-c创建cookie jar，未启用cookie引擎时返回nil
+func buildCookieJar() (*CookieJar, error) {
+	cookieFile := ""
+	if curlFlag.Cookie != "" && !strings.Contains(curlFlag.Cookie, "=") {
+		cookieFile = curlFlag.Cookie
+	}
+	if cookieFile == "" && curlFlag.CookieJar == "" {
+		return nil, nil
+	}
+
+	jar := NewCookieJar()
+	if cookieFile != "" {
+		f, err := os.Open(cookieFile)
+		if err != nil {
+			// 与curl一致，cookie文件不存在时仅告警
+			log.Warnf("read cookie file: %s error: %s", cookieFile, err.Error())
+			return jar, nil
+		}
+		defer f.Close()
+		if err := jar.Load(f); err != nil {
+			return nil, err
+		}
+		log.Trace("load cookies from file: " + cookieFile)
+	}
+	return jar, nil
+}
+
+// saveCookieJar 在所有传输结束后将cookie写入-c指定的文件
+func saveCookieJar(jar *CookieJar) error {
+	if jar == nil || curlFlag.CookieJar == "" {
+		return nil
+	}
+	if curlFlag.CookieJar == "-" {
+		return jar.Save(os.Stdout)
+	}
+	f, err := os.Create(curlFlag.CookieJar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := jar.Save(f); err != nil {
+		return err
+	}
+	log.Trace("save cookies to file: " + curlFlag.CookieJar)
+	return nil
+}
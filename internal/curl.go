@@ -1,12 +1,11 @@
 package internal
 
 import (
-	"context"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/http/httputil"
@@ -35,12 +34,27 @@ func fillBody(req *http.Request) error {
 	isChunked := req.Header.Get("Transfer-Encoding") == "chunked"
 
 	// set body
-	if strings.HasPrefix(curlFlag.Data, "@") {
-		// file data
-		filename := curlFlag.Data[1:]
+	if len(curlFlag.Data) == 1 && curlFlag.Data[0].isFile() {
+		// --data-binary @file 流式发送文件
+		filename := curlFlag.Data[0].value[1:]
 		isStdin := filename == "-"
 		log.Trace("set body content from file: " + filename)
-		if isStdin {
+		if isStdin && curlFlag.Retry > 0 {
+			// 重试时需要重新发送body，将标准输入缓存到内存中
+			bs, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			req.Body = io.NopCloser(bytes.NewReader(bs))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(bs)), nil
+			}
+			if isChunked {
+				req.ContentLength = -1
+			} else {
+				req.ContentLength = int64(len(bs))
+			}
+		} else if isStdin {
 			req.Body = os.Stdin
 		} else {
 			f, err := os.Open(filename)
@@ -48,6 +62,10 @@ func fillBody(req *http.Request) error {
 				return err
 			}
 			req.Body = f
+			// 重定向时需要重新读取文件
+			req.GetBody = func() (io.ReadCloser, error) {
+				return os.Open(filename)
+			}
 			if isChunked {
 				req.ContentLength = -1
 			} else {
@@ -57,52 +75,20 @@ func fillBody(req *http.Request) error {
 				}
 				req.ContentLength = stat.Size()
 			}
-
-			if curlFlag.ContentMD5 {
-				f, err := os.Open(filename)
-				if err != nil {
-					return err
-				}
-				md5, err := GetBase64MD5FromReader(f)
-				if err != nil {
-					return err
-				}
-				f.Close()
-				if isChunked {
-					if req.Trailer == nil {
-						req.Trailer = make(http.Header)
-					}
-					// chunked 传输在 trailer 添加 content-md5
-					req.Trailer.Set("Content-MD5", md5)
-					log.Trace("add trailer: Content-MD5: " + md5)
-				} else {
-					// 非 chunked 传输在 header 添加 content-md5
-					req.Header.Set("Content-MD5", md5)
-					log.Trace("add header: Content-MD5: " + md5)
-				}
-			}
 		}
-	} else if curlFlag.Data != "" {
-		// raw data
-		log.Trace("set body content: " + curlFlag.Data)
-		req.Body = io.NopCloser(strings.NewReader(curlFlag.Data))
-		req.ContentLength = int64(len(curlFlag.Data))
-		if curlFlag.ContentMD5 {
-			md5 := GetBase64MD5FromStr(curlFlag.Data)
-			if md5 == "" {
-				return fmt.Errorf("getBase64MD5FromStr error")
-			}
-			if isChunked {
-				if req.Trailer == nil {
-					req.Trailer = make(http.Header)
-				}
-				req.Trailer.Set("Content-MD5", md5)
-				log.Trace("add trailer: Content-MD5: " + md5)
-			} else {
-				req.Header.Set("Content-MD5", md5)
-				log.Trace("add header: Content-MD5: " + md5)
-			}
+	} else if len(curlFlag.Data) > 0 {
+		// --data、--data-binary、--data-raw、--data-urlencode 按顺序用&连接
+		bs, err := buildDataBody(curlFlag.Data)
+		if err != nil {
+			return err
+		}
+		data := string(bs)
+		log.Trace("set body content: " + data)
+		req.Body = io.NopCloser(strings.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data)), nil
 		}
+		req.ContentLength = int64(len(data))
 	} else if len(curlFlag.FormEntry) != 0 {
 		// form data
 		BuildFormData(req, curlFlag.FormEntry)
@@ -110,7 +96,7 @@ func fillBody(req *http.Request) error {
 	return nil
 }
 
-func buildUnsignedRequest(urlStr string) (*http.Request, error) {
+func buildUnsignedRequest(urlStr, uploadFile string) (*http.Request, error) {
 	urlStr = strings.TrimSpace(urlStr)
 	// 填充默认协议 http
 	if !strings.HasPrefix(urlStr, "http://") && !strings.HasPrefix(urlStr, "https://") {
@@ -131,31 +117,51 @@ func buildUnsignedRequest(urlStr string) (*http.Request, error) {
 		log.Trace("add header: User-Agent: " + curlFlag.UserAgent)
 	}
 
+	// -u 未用于签名时作为Basic认证
+	if curlFlag.User != "" && curlFlag.AwsSigV4 == "" && curlFlag.Sign == "" {
+		user, password, _ := strings.Cut(curlFlag.User, ":")
+		req.SetBasicAuth(user, password)
+		log.Trace("add header: Authorization: Basic <credentials>")
+	}
+
+	// -b "k=v; k2=v2" 直接作为Cookie请求头
+	if strings.Contains(curlFlag.Cookie, "=") {
+		req.Header.Set("Cookie", curlFlag.Cookie)
+		log.Trace("add header: Cookie: " + curlFlag.Cookie)
+	}
+
+	// -r / -C
+	if err := setRangeHeader(req); err != nil {
+		return nil, err
+	}
+	req = withResumeURL(req)
+
 	// 填充content-type
-	if curlFlag.Data != "" {
+	if len(curlFlag.Data) > 0 {
 		// 如果-d参数不为空，自动填充content-type
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		log.Trace("add header: Content-Type: application/x-www-form-urlencoded")
 	}
 
-	// 解析并添加body
-	if err := fillBody(req); err != nil {
+	// 解析并添加body，-T 上传文件
+	if uploadFile != "" {
+		if err := setUploadBody(req, uploadFile); err != nil {
+			return nil, err
+		}
+	} else if err := fillBody(req); err != nil {
 		return nil, err
 	}
 
 	// 最终用户显式输入的header优先级最高，可覆盖先前默认逻辑填充的header
 	if len(curlFlag.Header) > 0 {
 		// extra headers
-		for _, h := range curlFlag.Header {
-			idx := strings.Index(h, ":")
-			if idx == -1 {
-				return nil, fmt.Errorf("invalid header: %s", h)
-			} else {
-				k := strings.TrimSpace(h[:idx])
-				v := strings.TrimSpace(h[idx+1:])
-				req.Header.Set(k, v)
-				log.Tracef("add header: %s: %s", k, v)
-			}
+		headers, err := parseHeaderArgs(curlFlag.Header)
+		if err != nil {
+			return nil, err
+		}
+		applyHeaderArgs(req, headers)
+		if curlFlag.RawHeaders {
+			req = withRawHeaders(req, headers)
 		}
 		// set host header
 		if host := req.Header.Get("Host"); host != "" {
@@ -192,6 +198,16 @@ func buildUnsignedRequest(urlStr string) (*http.Request, error) {
 			}
 		}
 	}
+
+	// --http1.0
+	if err := prepareHTTP10Request(req); err != nil {
+		return nil, err
+	}
+
+	// --content-md5 / --digest 需要在body与header确定后计算
+	if err := applyDigests(req); err != nil {
+		return nil, err
+	}
 	return req, nil
 }
 
@@ -217,24 +233,16 @@ func outputResponse(resp *http.Response) error {
 		}
 	}
 
-	if !curlFlag.Head && log.GetLevel() >= log.DebugLevel {
-		logPrefix := "print response without body: "
-		if curlFlag.OutputResponseBodyOnVerbose {
-			logPrefix = "print response with body: "
-		}
-		bs, err := httputil.DumpResponse(resp, curlFlag.OutputResponseBodyOnVerbose)
-		if err != nil {
-			log.Error("httputil.DumpResponse error: ", err)
+	if !curlFlag.Head {
+		if err := logResponse(resp); err != nil {
 			return err
 		}
-		log.Infoln(logPrefix + "\n" + string(bs))
 	}
 
 	// don't output body if content-length == 0
 	if resp.ContentLength == 0 {
 		return nil
 	}
-
 	// output body
 	outputRaw := func(r io.Reader) error {
 		if curlFlag.OutputFile == "" {
@@ -245,18 +253,25 @@ func outputResponse(resp *http.Response) error {
 		}
 
 		// Save file is not empty, save response body to file
-		if f, err := os.Create(curlFlag.OutputFile); err != nil {
+		if f, err := openOutputFile(resp); err != nil {
 			return err
 		} else {
+			defer f.Close()
 			if _, err := io.Copy(f, r); err != nil {
 				return err
 			} else {
+				removeResumeMeta()
 				log.Println("save file success: " + curlFlag.OutputFile)
 			}
 			return nil
 		}
 	}
 
+	// multipart/byteranges
+	if isMultipartByteRanges(resp) {
+		return outputByteRanges(resp)
+	}
+
 	// Output response body directly
 	if !curlFlag.Pretty {
 		return outputRaw(resp.Body)
@@ -282,16 +297,14 @@ func outputResponse(resp *http.Response) error {
 		return
 	}
 
-	outputPrettyJson := func(bs []byte) (err error) {
-		if len(bs) == 0 {
-			return
-		}
+	outputPrettyJson := func(r io.Reader, seq bool) (err error) {
 		var file *os.File
 		if curlFlag.OutputFile != "" {
 			file, err = os.Create(curlFlag.OutputFile)
 			if err != nil {
 				return err
 			}
+			defer file.Close()
 		} else {
 			file = os.Stdout
 		}
@@ -301,35 +314,23 @@ func outputResponse(resp *http.Response) error {
 			return err
 		}
 
-		var prettyJson []byte
+		// 终端输出时着色，文件不着色
+		opts := jsonPrintOptions{compact: curlFlag.PrettyCompact, seq: seq}
 		if stat.Mode()&os.ModeCharDevice != 0 {
-			// 终端
-			prettyJson = pretty.Color(bs, pretty.TerminalStyle)
-		} else {
-			// 文件
-			prettyJson = pretty.Pretty(bs)
+			opts.style = pretty.TerminalStyle
 		}
-
-		_, err = file.Write(prettyJson)
-		if err != nil {
-			return err
-		}
-		return
+		return prettyPrintJSON(file, r, opts)
 	}
 
 	// Output response body with pretty format
 	contentType := resp.Header.Get("Content-Type")
+	stream, seq := jsonStreamType(contentType)
 	switch {
+	case stream:
+		// NDJSON、JSON Lines与json-seq逐条输出，不等待响应结束
+		return outputPrettyJson(resp.Body, seq)
 	case strings.HasPrefix(contentType, "application/json"):
-		var body any
-		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
-			return err
-		}
-		if bs, err := json.MarshalIndent(body, "", "   "); err != nil {
-			return err
-		} else {
-			return outputPrettyJson(bs)
-		}
+		return outputPrettyJson(resp.Body, false)
 	case strings.HasPrefix(contentType, "application/bson"):
 		// 读取bson转化为json并格式化输出
 		bs, err := io.ReadAll(resp.Body)
@@ -344,10 +345,10 @@ func outputResponse(resp *http.Response) error {
 		if err := decoder.Decode(&body); err != nil {
 			return err
 		}
-		if bs, err := json.MarshalIndent(body, "", "   "); err != nil {
+		if bs, err := json.Marshal(body); err != nil {
 			return err
 		} else {
-			return outputPrettyJson(bs)
+			return outputPrettyJson(bytes.NewReader(bs), false)
 		}
 	case strings.HasPrefix(contentType, "application/xml"):
 		// 读取xml并格式化输出
@@ -362,6 +363,25 @@ func outputResponse(resp *http.Response) error {
 	}
 }
 
+func logResponse(resp *http.Response) error {
+	// Print response message
+	if log.GetLevel() < log.DebugLevel {
+		return nil
+	}
+	logProtocol(resp)
+	logPrefix := "print response without body: "
+	if curlFlag.OutputResponseBodyOnVerbose {
+		logPrefix = "print response with body: "
+	}
+	bs, err := httputil.DumpResponse(resp, curlFlag.OutputResponseBodyOnVerbose)
+	if err != nil {
+		log.Error("httputil.DumpResponse error: ", err)
+		return err
+	}
+	log.Infoln(logPrefix + "\n" + string(bs))
+	return nil
+}
+
 func outputRequest(req *http.Request) error {
 	// Print request message
 	if log.GetLevel() < log.DebugLevel {
@@ -412,7 +432,7 @@ var cmd = &cobra.Command{
 		return
 	},
 
-	RunE: func(cmd *cobra.Command, args []string) error {
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		if curlFlag.Version {
 			version.PrintVersionInfo()
 			return nil
@@ -442,68 +462,181 @@ var cmd = &cobra.Command{
 			return errors.New("url argument is required")
 		}
 
-		// build request
-		log.Trace("build request: " + urlStr)
-		req, err := buildUnsignedRequest(urlStr)
-		if err != nil {
-			return err
+		// --raw-request 不构造请求，原样发送
+		if curlFlag.RawRequest != "" {
+			return sendRawRequest(urlStr)
 		}
 
-		// send request and receive response
-		c := http.Client{
-			Transport: &http.Transport{
-				Proxy: func(req *http.Request) (*url.URL, error) {
-					if curlFlag.Proxy != "" {
-						return url.Parse(curlFlag.Proxy)
-					}
-					return http.ProxyFromEnvironment(req)
-				},
-				ForceAttemptHTTP2:     true,
-				MaxIdleConns:          100,
-				IdleConnTimeout:       90 * time.Second,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
-				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					dialer := &net.Dialer{
-						Timeout:   time.Duration(curlFlag.ConnectTimeout * float64(time.Second)),
-						KeepAlive: 30 * time.Second,
-						Resolver:  net.DefaultResolver,
-					}
-					return dialer.DialContext(ctx, network, addr)
-				},
-			},
-			Timeout: time.Duration(curlFlag.MaxTime * float64(time.Second)),
-		}
-
-		// output request
-		if err := outputRequest(req); err != nil {
+		// cookie engine，多个传输共享同一个jar，全部结束后保存一次
+		jar, err := buildCookieJar()
+		if err != nil {
 			return err
 		}
+		defer func() {
+			if err := saveCookieJar(jar); err != nil {
+				log.Error("save cookie jar error: ", err)
+			}
+		}()
 
-		if curlFlag.Trace {
-			req = req.WithContext(httptrace.WithClientTrace(req.Context(), BuildClientTrace()))
+		// -T 支持 {a,b} 与 [1-3]，每个文件单独上传
+		if curlFlag.UploadFile != "" {
+			files, err := expandGlob(curlFlag.UploadFile)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if err := transfer(uploadURL(urlStr, file), file, jar); err != nil {
+					return err
+				}
+			}
+			return nil
 		}
+		return transfer(urlStr, "", jar)
+	},
+}
 
-		resp, err := c.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
+// transfer 发送一个请求并输出响应，uploadFile不为空时上传该文件，jar为nil时不启用cookie引擎
+func transfer(urlStr, uploadFile string, jar *CookieJar) (err error) {
+	// build request
+	log.Trace("build request: " + urlStr)
+	req, err := buildUnsignedRequest(urlStr, uploadFile)
+	if err != nil {
+		return err
+	}
 
-		// output response
-		if err := outputResponse(resp); err != nil {
-			return err
-		}
+	// sign request
+	if err := signRequest(req); err != nil {
+		return err
+	}
+
+	// 签名需要读取body，签名后再限速
+	limiters, err := buildRateLimiters()
+	if err != nil {
+		return err
+	}
+	limiters.limitRequestBody(req)
+
+	tlsConfig, err := buildTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	proxy, err := buildProxy(req.URL)
+	if err != nil {
+		return err
+	}
+	resolver, err := buildHostResolver()
+	if err != nil {
+		return err
+	}
+	dialContext, err := buildDialContext(resolver, proxy)
+	if err != nil {
+		return err
+	}
+
+	// send request and receive response
+	c := http.Client{
+		Transport: proxy.wrapTransport(buildTransport(&http.Transport{
+			Proxy:                 proxy.forRequest,
+			TLSClientConfig:       tlsConfig,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			DialContext:           dialContext,
+		})),
+		// 重定向由doRequest按curl语义处理
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: time.Duration(curlFlag.MaxTime * float64(time.Second)),
+	}
+
+	// HTTP/3 与 alt-svc
+	altSvc := loadAltSvcCache()
+	defer altSvc.save()
+	h3, err := buildHTTP3Transport(c.Transport, resolver, proxy, tlsConfig, altSvc)
+	if err != nil {
+		return err
+	}
+	defer h3.Close()
+	c.Transport = h3
+
+	// cookie engine
+	if jar != nil {
+		c.Jar = jar
+	}
+
+	// write out
+	info := NewTransferInfo()
+	info.URL = req.URL.String()
+	if curlFlag.WriteOut != "" {
+		format, ferr := loadWriteOutFormat(curlFlag.WriteOut)
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			info.Finish(info.Response, err)
+			if err := info.WriteOut(format); err != nil {
+				log.Error("write out error: ", err)
+			}
+		}()
+		info.WrapRequest(req)
+	}
+
+	if curlFlag.Trace || curlFlag.WriteOut != "" {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), BuildClientTrace(info)))
+	}
 
-		if resp.StatusCode >= 400 {
-			fmt.Println()
-			err = fmt.Errorf("request failed with response status code: %d", resp.StatusCode)
-			log.Error(err)
+	// progress meter
+	progress := newProgress()
+	progress.WrapRequest(req)
+	progress.Start()
+	defer progress.Stop()
+
+	var resp *http.Response
+	if curlFlag.Segments > 1 {
+		// 分段下载完成时resp为nil，否则按普通下载处理resp
+		if resp, err = downloadSegments(&c, req, progress, limiters); err != nil || resp == nil {
 			return err
 		}
-
+	} else if resp, err = doRequestWithRetry(&c, req, info); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	info.Response = resp
+	resp.Body = limiters.limitResponseBody(resp.Body)
+	resp.Body = &countingReadCloser{ReadCloser: resp.Body, n: &info.SizeDownload}
+	resp.Body = progress.WrapDownload(resp.Body, resp.ContentLength)
+
+	if isResumeComplete(resp) {
+		log.Info("the file is already fully retrieved, nothing to resume")
 		return nil
-	},
+	}
+
+	// --verify-digest 在输出body的同时计算摘要
+	var verifier *digestVerifier
+	if curlFlag.VerifyDigest && !curlFlag.Head {
+		verifier = newDigestVerifier(resp)
+	}
+
+	// output response
+	if err := outputResponse(resp); err != nil {
+		return err
+	}
+	if err := verifier.verify(); err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		fmt.Println()
+		err = &statusError{code: resp.StatusCode}
+		log.Error(err)
+		return err
+	}
+
+	return nil
 }
 
 func Execute() error {
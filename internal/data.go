@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// dataKind --data系列参数的类型，决定@file与内容的处理方式
+type dataKind int
+
+const (
+	// -d / --data 读取文件时去掉CR与LF
+	dataASCII dataKind = iota
+	// --data-binary 文件内容原样发送
+	dataBinary
+	// --data-raw 不解析@
+	dataRaw
+	// --data-urlencode 对内容进行URL编码
+	dataURLEncode
+)
+
+// dataArg 一个--data系列参数，按命令行中的顺序保存
+type dataArg struct {
+	kind  dataKind
+	value string
+}
+
+// isFile --data-binary @file 可以直接流式发送文件
+func (a dataArg) isFile() bool {
+	return a.kind == dataBinary && strings.HasPrefix(a.value, "@")
+}
+
+// dataFlag 实现pflag.Value，多个flag追加到同一个列表以保留顺序
+type dataFlag struct {
+	kind dataKind
+	args *[]dataArg
+}
+
+func (f *dataFlag) String() string { return "" }
+
+func (f *dataFlag) Set(value string) error {
+	*f.args = append(*f.args, dataArg{kind: f.kind, value: value})
+	return nil
+}
+
+func (f *dataFlag) Type() string { return "string" }
+
+// buildDataBody 按curl的规则处理每个参数，并用&连接
+func buildDataBody(args []dataArg) ([]byte, error) {
+	parts := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		part, err := arg.content()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return bytes.Join(parts, []byte("&")), nil
+}
+
+func (a dataArg) content() ([]byte, error) {
+	switch a.kind {
+	case dataURLEncode:
+		return urlEncodeData(a.value)
+	case dataRaw:
+		return []byte(a.value), nil
+	}
+	if !strings.HasPrefix(a.value, "@") {
+		return []byte(a.value), nil
+	}
+	bs, err := readDataFile(a.value[1:])
+	if err != nil {
+		return nil, err
+	}
+	if a.kind == dataASCII {
+		bs = bytes.ReplaceAll(bs, []byte("\r"), nil)
+		bs = bytes.ReplaceAll(bs, []byte("\n"), nil)
+	}
+	return bs, nil
+}
+
+// urlEncodeData 支持 content、=content、name=content、@file、name@file
+func urlEncodeData(arg string) ([]byte, error) {
+	name, content := "", arg
+	sep := strings.IndexByte(arg, '=')
+	if sep < 0 {
+		sep = strings.IndexByte(arg, '@')
+	}
+	if sep >= 0 {
+		name, content = arg[:sep], arg[sep+1:]
+		if arg[sep] == '@' {
+			bs, err := readDataFile(content)
+			if err != nil {
+				return nil, err
+			}
+			content = string(bs)
+		}
+	}
+	encoded := urlEscape(content)
+	if name == "" {
+		return []byte(encoded), nil
+	}
+	return []byte(name + "=" + encoded), nil
+}
+
+// urlEscape 与curl一致，空格编码为%20
+func urlEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// readDataFile 读取文件内容，-表示从stdin读取
+func readDataFile(filename string) ([]byte, error) {
+	if filename == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(filename)
+}
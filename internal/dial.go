@@ -0,0 +1,301 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dialContextFunc 与http.Transport.DialContext的签名一致
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// hostResolver 处理--connect-to、--resolve、-4/-6与自定义DNS解析
+type hostResolver struct {
+	overrides resolveOverrides
+	rules     []connectTo
+	resolver  *net.Resolver
+}
+
+func buildHostResolver() (*hostResolver, error) {
+	overrides, err := parseResolve(curlFlag.Resolve)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseConnectTo(curlFlag.ConnectTo)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := buildResolver()
+	if err != nil {
+		return nil, err
+	}
+	return &hostResolver{overrides: overrides, rules: rules, resolver: resolver}, nil
+}
+
+// connectTo 返回--connect-to后实际连接的host与port
+func (r *hostResolver) connectTo(host, port string) (string, string) {
+	h, p := applyConnectTo(r.rules, host, port)
+	if h != host || p != port {
+		log.Debugf("connect to %s instead of %s", net.JoinHostPort(h, p), net.JoinHostPort(host, port))
+	}
+	return h, p
+}
+
+// lookup 解析host，优先使用--resolve指定的地址，返回的地址按-4/-6过滤且IPv4优先
+func (r *hostResolver) lookup(ctx context.Context, host, port string) ([]net.IP, error) {
+	ips, ok := r.overrides.lookup(host, port)
+	if !ok {
+		addrs, err := r.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	var result []net.IP
+	for _, ip := range ips {
+		if curlFlag.IPv4 && ip.To4() == nil || curlFlag.IPv6 && ip.To4() != nil {
+			continue
+		}
+		result = append(result, ip)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no address of the requested family for " + host)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return ipPreference(result[i]) < ipPreference(result[j])
+	})
+	return result, nil
+}
+
+// buildDialContext 创建建立连接的函数，处理域名解析、本地地址绑定与代理
+func buildDialContext(r *hostResolver, proxy *proxyConfig) (dialContextFunc, error) {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(curlFlag.ConnectTimeout * float64(time.Second)),
+		KeepAlive: 30 * time.Second,
+		Resolver:  r.resolver,
+	}
+
+	// unix socket 不改变url中的host，只改变连接的地址
+	if socket, err := unixSocketAddr(); err != nil {
+		return nil, err
+	} else if socket != "" {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			log.Debugf("connect to unix socket %s for %s", socket, addr)
+			return dialer.DialContext(ctx, "unix", socket)
+		}, nil
+	}
+
+	binding, err := parseLocalBinding()
+	if err != nil {
+		return nil, err
+	}
+	dial := binding.wrap(dialer)
+
+	direct := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// -4/-6 限制地址族
+		if network == "tcp" {
+			switch {
+			case curlFlag.IPv4:
+				network = "tcp4"
+			case curlFlag.IPv6:
+				network = "tcp6"
+			}
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		host, port = r.connectTo(host, port)
+		ips, ok := r.overrides.lookup(host, port)
+		if !ok {
+			return dial(ctx, network, net.JoinHostPort(host, port))
+		}
+		return dialResolved(ctx, dial, network, host, port, ips)
+	}
+	return proxy.wrapDial(direct, r), nil
+}
+
+// unixSocketAddr 返回--unix-socket或--abstract-unix-socket对应的地址，abstract socket以@开头
+func unixSocketAddr() (string, error) {
+	switch {
+	case curlFlag.AbstractUnixSocket != "":
+		if runtime.GOOS != "linux" {
+			return "", errors.New("--abstract-unix-socket is only supported on linux")
+		}
+		return "@" + curlFlag.AbstractUnixSocket, nil
+	case curlFlag.UnixSocket != "":
+		return curlFlag.UnixSocket, nil
+	}
+	return "", nil
+}
+
+// dialResolved 按顺序连接--resolve指定的地址，直到连接成功
+func dialResolved(ctx context.Context, dial dialContextFunc, network, host, port string, ips []net.IP) (net.Conn, error) {
+	// 不经过DNS解析，需要手动触发DNS相关的trace事件
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		switch {
+		case strings.HasSuffix(network, "4") && ip.To4() == nil:
+		case strings.HasSuffix(network, "6") && ip.To4() != nil:
+		default:
+			addrs = append(addrs, net.IPAddr{IP: ip})
+		}
+	}
+	var err error
+	if len(addrs) == 0 {
+		err = errors.New("no address of the requested family for " + host)
+	}
+	if trace != nil && trace.DNSDone != nil {
+		trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("resolve %s to %v", net.JoinHostPort(host, port), addrs)
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dial(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// localBinding --interface与--local-port指定的本地地址，port为0时由系统分配
+type localBinding struct {
+	ip               net.IP
+	portFrom, portTo int
+}
+
+// parseLocalBinding 解析 --interface <name|ip> 与 --local-port N[-M]，都未指定时返回nil
+func parseLocalBinding() (*localBinding, error) {
+	if curlFlag.Interface == "" && curlFlag.LocalPort == "" {
+		return nil, nil
+	}
+	binding := &localBinding{}
+	if curlFlag.Interface != "" {
+		ip, err := interfaceIP(curlFlag.Interface)
+		if err != nil {
+			return nil, err
+		}
+		binding.ip = ip
+	}
+	if curlFlag.LocalPort != "" {
+		invalid := fmt.Errorf("invalid local port: %s, format: N[-M]", curlFlag.LocalPort)
+		from, to, isRange := strings.Cut(curlFlag.LocalPort, "-")
+		var err error
+		if binding.portFrom, err = strconv.Atoi(from); err != nil {
+			return nil, invalid
+		}
+		binding.portTo = binding.portFrom
+		if isRange {
+			if binding.portTo, err = strconv.Atoi(to); err != nil {
+				return nil, invalid
+			}
+		}
+		if binding.portFrom < 1 || binding.portTo > 65535 || binding.portFrom > binding.portTo {
+			return nil, invalid
+		}
+	}
+	return binding, nil
+}
+
+// interfaceIP 获取--interface对应的本地IP，支持curl的if!name与host!name前缀；
+// 网卡有多个地址时优先使用IPv4地址，指定-6时使用IPv6地址
+func interfaceIP(iface string) (net.IP, error) {
+	if host, ok := strings.CutPrefix(iface, "host!"); ok {
+		iface = host
+	} else if ip := net.ParseIP(iface); ip != nil {
+		return ip, nil
+	}
+	name, byName := strings.CutPrefix(iface, "if!")
+	if ifi, err := net.InterfaceByName(name); err == nil {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		var candidates []net.IP
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				candidates = append(candidates, ipNet.IP)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return ipPreference(candidates[i]) < ipPreference(candidates[j])
+		})
+		for _, ip := range candidates {
+			if curlFlag.IPv6 && ip.To4() != nil || curlFlag.IPv4 && ip.To4() == nil {
+				continue
+			}
+			log.Debugf("use local address %s of interface %s", ip, name)
+			return ip, nil
+		}
+		return nil, fmt.Errorf("interface %s has no usable address", name)
+	} else if byName {
+		return nil, err
+	}
+	// 既不是IP也不是网卡名时作为主机名解析
+	ips, err := net.LookupIP(iface)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface: %s", iface)
+	}
+	return ips[0], nil
+}
+
+// ipPreference IPv4优先，其次是非链路本地的IPv6地址
+func ipPreference(ip net.IP) int {
+	switch {
+	case ip.To4() != nil:
+		return 0
+	case !ip.IsLinkLocalUnicast():
+		return 1
+	}
+	return 2
+}
+
+// wrap 返回绑定本地地址后建立连接的函数，指定端口范围时依次尝试直到绑定成功
+func (b *localBinding) wrap(dialer *net.Dialer) dialContextFunc {
+	if b == nil {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := *dialer
+		if b.portFrom == 0 {
+			d.LocalAddr = &net.TCPAddr{IP: b.ip}
+			return d.DialContext(ctx, network, addr)
+		}
+		for port := b.portFrom; port <= b.portTo; port++ {
+			d.LocalAddr = &net.TCPAddr{IP: b.ip, Port: port}
+			conn, err := d.DialContext(ctx, network, addr)
+			if err == nil {
+				log.Debugf("bind local port: %d", port)
+				return conn, nil
+			}
+			if !errors.Is(err, syscall.EADDRINUSE) {
+				return nil, err
+			}
+			log.Tracef("local port %d is in use, try next", port)
+		}
+		return nil, fmt.Errorf("bind local port failed, all ports in %d-%d are in use", b.portFrom, b.portTo)
+	}
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// digestHashes 摘要算法名对应的hash，算法名与RFC 9530中的一致，--digest与--verify-digest共用
+var digestHashes = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+	"crc32c":  func() hash.Hash { return crc32.New(crc32cTable) },
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// digestAlgorithm --digest 支持的摘要，header相同的多个摘要合并为一个header
+type digestAlgorithm struct {
+	name      string
+	header    string
+	algorithm string
+	// structured 为true时使用RFC 9530的格式: sha-256=:base64:，否则为base64
+	structured bool
+}
+
+func (d digestAlgorithm) format(sum []byte) string {
+	if d.structured {
+		return d.algorithm + "=:" + base64Digest(sum) + ":"
+	}
+	return base64Digest(sum)
+}
+
+func base64Digest(sum []byte) string {
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// md5ETagRegexp 非弱校验且为32位十六进制的ETag通常是内容的MD5，如S3的非分片上传对象
+var md5ETagRegexp = regexp.MustCompile(`^"?([0-9a-fA-F]{32})"?$`)
+
+// md5ETag 返回ETag中的MD5，弱校验或不是MD5形式的ETag返回nil，分段下载与--verify-digest共用
+func md5ETag(etag string) []byte {
+	m := md5ETagRegexp.FindStringSubmatch(etag)
+	if m == nil {
+		return nil
+	}
+	sum, _ := hex.DecodeString(m[1])
+	return sum
+}
+
+var digestAlgorithms = []digestAlgorithm{
+	{"md5", "Content-MD5", "md5", false},
+	{"sha256", "X-Amz-Checksum-Sha256", "sha-256", false},
+	{"crc32c", "X-Amz-Checksum-Crc32c", "crc32c", false},
+	{"content-sha-256", "Content-Digest", "sha-256", true},
+	{"content-sha-512", "Content-Digest", "sha-512", true},
+	{"repr-sha-256", "Repr-Digest", "sha-256", true},
+	{"repr-sha-512", "Repr-Digest", "sha-512", true},
+}
+
+// buildDigests 解析--digest，--content-md5 等同于 --digest md5
+func buildDigests() ([]digestAlgorithm, error) {
+	names := curlFlag.Digest
+	if curlFlag.ContentMD5 {
+		names = append([]string{"md5"}, names...)
+	}
+	var digests []digestAlgorithm
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		found := false
+		for _, d := range digestAlgorithms {
+			if d.name == name {
+				digests = append(digests, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			var valid []string
+			for _, d := range digestAlgorithms {
+				valid = append(valid, d.name)
+			}
+			return nil, fmt.Errorf("invalid digest: %s, valid digests: %s", name, strings.Join(valid, ", "))
+		}
+	}
+	return digests, nil
+}
+
+// applyDigests 计算请求body的摘要。body可重放且长度已知时预先计算并放在header中，
+// chunked或管道输入的body在发送时计算，读取完成后放在trailer中
+func applyDigests(req *http.Request) error {
+	digests, err := buildDigests()
+	if err != nil || len(digests) == 0 {
+		return err
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	// ContentLength为0且没有GetBody时长度未知，如stdin，长度为0的body如 -d '' 在header中发送摘要
+	chunked := req.ContentLength < 0 || req.ContentLength == 0 && req.GetBody == nil ||
+		strings.EqualFold(req.Header.Get("Transfer-Encoding"), "chunked")
+	if req.GetBody != nil && !chunked {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		r := newDigestReader(body, digests)
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		for k, v := range r.values() {
+			req.Header.Set(k, v)
+			log.Tracef("add header: %s: %s", k, v)
+		}
+		if req.ContentLength == 0 {
+			// 非nil的body长度为0时http.Transport会按长度未知使用chunked编码
+			req.Body.Close()
+			req.Body = http.NoBody
+		}
+		return nil
+	}
+
+	// trailer只能通过chunked编码发送
+	req.ContentLength = -1
+	if req.Trailer == nil {
+		req.Trailer = make(http.Header)
+	}
+	for _, d := range digests {
+		req.Trailer[d.header] = nil
+	}
+	r := newDigestReader(req.Body, digests)
+	r.trailer = req.Trailer
+	req.Body = &readCloser{Reader: r, Closer: req.Body}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			r := newDigestReader(body, digests)
+			r.trailer = req.Trailer
+			return &readCloser{Reader: r, Closer: body}, nil
+		}
+	}
+	return nil
+}
+
+// digestReader 在读取body的同时计算摘要，trailer不为nil时读取到EOF后写入trailer
+type digestReader struct {
+	r       io.Reader
+	digests []digestAlgorithm
+	hashes  []hash.Hash
+	w       io.Writer
+	trailer http.Header
+}
+
+func newDigestReader(r io.Reader, digests []digestAlgorithm) *digestReader {
+	d := &digestReader{r: r, digests: digests}
+	writers := make([]io.Writer, 0, len(digests))
+	for _, digest := range digests {
+		h := digestHashes[digest.algorithm]()
+		d.hashes = append(d.hashes, h)
+		writers = append(writers, h)
+	}
+	d.w = io.MultiWriter(writers...)
+	return d
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.w.Write(p[:n])
+	if err == io.EOF && d.trailer != nil {
+		for k, v := range d.values() {
+			d.trailer.Set(k, v)
+			log.Tracef("add trailer: %s: %s", k, v)
+		}
+	}
+	return n, err
+}
+
+// values 返回每个header的值，同一header的多个摘要以逗号分隔
+func (d *digestReader) values() map[string]string {
+	values := map[string]string{}
+	for i, digest := range d.digests {
+		v := digest.format(d.hashes[i].Sum(nil))
+		if prev, ok := values[digest.header]; ok {
+			v = prev + ", " + v
+		}
+		values[digest.header] = v
+	}
+	return values
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExitCodeDigestMismatch --verify-digest 校验失败时的退出码，curl的退出码目前到101，
+// 使用curl未使用的值避免与curl的错误码混淆
+const ExitCodeDigestMismatch = 120
+
+// statusError 响应状态码>=400，进程以1退出，但与curl未指定-f时一致，--write-out的exitcode为0
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("request failed with response status code: %d", e.code)
+}
+
+// exitCode 返回err对应的退出码，用于--write-out的exitcode
+func exitCode(err error) int {
+	var exitErr *ExitError
+	var statusErr *statusError
+	switch {
+	case err == nil, errors.As(err, &statusErr):
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	}
+	return 1
+}
+
+// ExitError 需要以指定退出码结束进程的错误
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
@@ -25,20 +25,35 @@ type Flags struct {
 	Request string
 
 	Header []string
+	// --raw-headers 按-H的原样发送header，保留大小写、顺序与重复项
+	RawHeaders bool
+	// --raw-request 原样发送文件中的请求，-表示从stdin读取
+	RawRequest string
 
 	FormEntry []string
 
-	// Body
-	Data string
+	// -T / --upload-file 上传的文件，支持 {a,b} 与 [1-3]，-表示stdin
+	UploadFile string
+
+	// Body, -d / --data-binary / --data-raw / --data-urlencode 按顺序保存
+	Data []dataArg
 
 	// output pretty response body
 	Pretty bool
+	// --pretty-compact 每个json值着色输出为一行
+	PrettyCompact bool
 
 	// Save response body to file
 	OutputFile string
 
 	// 自动计算并添加content-md5请求头
 	ContentMD5 bool
+	// --digest 计算请求body的摘要，放在header或trailer中
+	Digest []string
+	// --verify-digest 校验响应body的Content-MD5、Content-Digest或MD5 ETag
+	VerifyDigest bool
+	// --keep-bad-output 校验失败时保留-o的文件
+	KeepBadOutput bool
 
 	// 是否输出版本信息
 	Version bool
@@ -48,6 +63,18 @@ type Flags struct {
 
 	// 使用代理[protocol://]host[:port]
 	Proxy string
+	// --proxy-user 代理的用户名与密码 <user:password>
+	ProxyUser string
+	// --proxy-header 发送给代理的请求头
+	ProxyHeader []string
+	// --noproxy 不使用代理的host列表
+	NoProxy string
+	// --proxytunnel 明文http请求也通过CONNECT隧道访问
+	ProxyTunnel bool
+	// --proxy-cacert https代理使用的CA证书文件
+	ProxyCACert string
+	// --proxy-insecure 跳过https代理的证书校验
+	ProxyInsecure bool
 
 	// --trace使用TRACE级别日志
 	Trace bool
@@ -65,6 +92,133 @@ type Flags struct {
 	MaxTime float64
 	// --connect-timeout
 	ConnectTimeout float64
+
+	// -L / --location 跟随重定向
+	Location bool
+	// --max-redirs 最大重定向次数，-1表示不限制
+	MaxRedirs int
+	// --post301/--post302/--post303 重定向时不将POST转换为GET
+	Post301 bool
+	Post302 bool
+	Post303 bool
+
+	// -b / --cookie 发送的cookie "k=v; k2=v2"，或读取cookie的文件
+	Cookie string
+	// -c / --cookie-jar 传输结束后写入cookie的文件
+	CookieJar string
+
+	// -w / --write-out 传输结束后按格式输出传输信息
+	WriteOut string
+
+	// -k / --insecure 跳过服务端证书校验
+	Insecure bool
+	// --cacert 使用的CA证书文件
+	CACert string
+	// --capath 使用的CA证书目录
+	CAPath string
+	// --cert 客户端证书 <file[:password]>
+	Cert string
+	// --cert-type 客户端证书类型 PEM|P12
+	CertType string
+	// --key 客户端私钥
+	Key string
+	// --pass 私钥或PKCS#12文件的密码
+	Pass string
+	// --tlsv1.0 ~ --tlsv1.3 最低TLS版本
+	TLSv10 bool
+	TLSv11 bool
+	TLSv12 bool
+	TLSv13 bool
+	// --tls-max 最高TLS版本
+	TLSMax string
+	// --ciphers cipher列表
+	Ciphers string
+	// --pinnedpubkey 固定服务端公钥
+	PinnedPubKey string
+
+	// -u / --user 用户名与密码 <user:password>，用于Basic认证或签名凭证
+	User string
+	// --aws-sigv4 使用AWS Signature V4签名 provider1[:provider2[:region[:service]]]
+	AwsSigV4 string
+	// --sign 签名方式 <scheme>[:param]
+	Sign string
+	// --profile 凭证文件中使用的profile
+	Profile string
+	// --credentials-file 凭证文件，默认为~/.curl-go/credentials
+	CredentialsFile string
+	// --jwt-claims JWT签名时额外的claims
+	JWTClaims string
+	// --jwt-expire JWT有效期
+	JWTExpire float64
+
+	// --retry 失败时的重试次数
+	Retry int
+	// --retry-delay 重试间隔，指定后不再使用指数退避
+	RetryDelay float64
+	// --retry-max-time 重试的总时间限制
+	RetryMaxTime float64
+	// --retry-all-errors 任何错误都进行重试
+	RetryAllErrors bool
+	// --retry-connrefused 连接被拒绝时也进行重试
+	RetryConnRefused bool
+
+	// -r / --range 请求的字节范围
+	Range string
+	// -C / --continue-at 断点续传的偏移，"-"表示自动使用-o文件的大小
+	ContinueAt string
+
+	// --segments 分段并发下载的分段数
+	Segments int
+
+	// -# / --progress-bar 使用进度条展示传输进度
+	ProgressBar bool
+	// --no-progress-meter 不展示传输进度
+	NoProgressMeter bool
+
+	// --limit-rate 上传与下载的最大速率
+	LimitRate string
+	// --limit-rate-upload 上传的最大速率，覆盖--limit-rate
+	LimitRateUpload string
+	// --limit-rate-download 下载的最大速率，覆盖--limit-rate
+	LimitRateDownload string
+
+	// --resolve 指定host与port解析到的地址 <host:port:addr[,addr]>
+	Resolve []string
+	// --connect-to 将对HOST1:PORT1的连接改为连接HOST2:PORT2
+	ConnectTo []string
+	// -4 / --ipv4 只使用IPv4地址
+	IPv4 bool
+	// -6 / --ipv6 只使用IPv6地址
+	IPv6 bool
+	// --dns-servers 使用的DNS服务器 <addr[:port]>
+	DNSServers []string
+	// --doh-url 使用DNS over HTTPS解析
+	DoHURL string
+
+	// --unix-socket 通过unix socket连接
+	UnixSocket string
+	// --abstract-unix-socket 通过abstract unix socket连接
+	AbstractUnixSocket string
+
+	// -0 / --http1.0 使用HTTP/1.0
+	HTTP10 bool
+	// --http1.1 使用HTTP/1.1
+	HTTP11 bool
+	// --http2 https通过ALPN协商使用HTTP/2，明文http仍使用HTTP/1.1
+	HTTP2 bool
+	// --http2-prior-knowledge 明文http直接使用HTTP/2 (h2c)
+	HTTP2PriorKnowledge bool
+	// --http3 尝试使用HTTP/3，失败时回退到TCP
+	HTTP3 bool
+	// --http3-only 只使用HTTP/3
+	HTTP3Only bool
+	// --alt-svc 读写Alt-Svc缓存文件
+	AltSvc string
+
+	// --interface 使用的本地网卡、IP或主机名
+	Interface string
+	// --local-port 使用的本地端口或端口范围 N[-M]
+	LocalPort string
 }
 
 func (f *Flags) validateMethodFlag() error {
@@ -88,13 +242,20 @@ func (f *Flags) validateMethodFlag() error {
 }
 
 func (f *Flags) ValidateAndFillDefault() (err error) {
+	// --pretty-compact 隐含 -p
+	if f.PrettyCompact {
+		f.Pretty = true
+	}
+
 	// 默认method填充
 	if f.Request == "" {
 		// 如果有表单且未修改默认 method 为 POST，自动修改 method 为 POST
 		switch {
 		case len(f.FormEntry) > 0:
 			f.Request = http.MethodPost
-		case f.Data != "":
+		case f.UploadFile != "":
+			f.Request = http.MethodPut
+		case len(f.Data) > 0:
 			f.Request = http.MethodPost
 		case f.Head:
 			f.Request = http.MethodHead
@@ -124,17 +285,33 @@ func (f *Flags) RegisterForCommand(cmd *cobra.Command) {
 		cmd.Flags().StringVar(&f.URL, "url", "", "Request url")
 		cmd.Flags().StringVarP(&f.UserAgent, "user-agent", "A", version.GetDefaultUserAgent(), "Set header User-Agent")
 		cmd.Flags().StringVarP(&f.Request, "request", "X", "", "Request Method (GET|POST|PUT|DELETE|HEAD|OPTIONS|PATCH)")
-		cmd.Flags().StringSliceVarP(&f.Header, "header", "H", []string{}, `Header (key:value), for example: "Content-Type:application/json", "Content-Type:application/xml", "Content-Type:application/octet-stream", "Content-Type:application/x-www-form-urlencoded"`)
+		cmd.Flags().StringArrayVarP(&f.Header, "header", "H", []string{}, `Header (key:value, "key:" removes a default header, "key;" sends an empty value), for example: "Content-Type:application/json", "Content-Type:application/xml", "Content-Type:application/octet-stream", "Content-Type:application/x-www-form-urlencoded"`)
 
 		// request body from
 		{
-			cmd.Flags().StringVarP(&f.Data, "data", "d", "", "Body data, use @filename to read from file")
+			cmd.Flags().VarP(&dataFlag{kind: dataASCII, args: &f.Data}, "data", "d", "HTTP POST data, use @filename to read from file with CR/LF stripped, repeated data is joined with &")
+			cmd.Flags().Var(&dataFlag{kind: dataBinary, args: &f.Data}, "data-binary", "HTTP POST binary data, use @filename to read from file as is")
+			cmd.Flags().Var(&dataFlag{kind: dataRaw, args: &f.Data}, "data-raw", "HTTP POST data, @ is not interpreted")
+			cmd.Flags().Var(&dataFlag{kind: dataURLEncode, args: &f.Data}, "data-urlencode", "HTTP POST data url encoded: content, =content, name=content, @file or name@file")
 			cmd.Flags().StringSliceVarP(&f.FormEntry, "form", "F", []string{}, "Form data (key=value), use @filename to read from file")
-			cmd.MarkFlagsMutuallyExclusive("data", "form")
+			cmd.Flags().StringVarP(&f.UploadFile, "upload-file", "T", "", "Transfer local file to destination with PUT, use - for stdin, {a,b} and [1-3] upload multiple files")
+			for _, data := range []string{"data", "data-binary", "data-raw", "data-urlencode"} {
+				cmd.MarkFlagsMutuallyExclusive(data, "form", "upload-file")
+			}
 		}
 
 		cmd.Flags().StringVarP(&f.OutputFile, "output", "o", "", "Save response body to file")
 
+		// Range
+		{
+			cmd.Flags().StringVarP(&f.Range, "range", "r", "", "Retrieve only the bytes within RANGE, for example: 0-1023, 500-, -500, 0-99,200-299")
+			cmd.Flags().StringVarP(&f.ContinueAt, "continue-at", "C", "", "Resumed transfer offset, use - to resume from the size of output file")
+			cmd.Flags().IntVar(&f.Segments, "segments", 0, "Download with N concurrent range requests into output file")
+		}
+
+		// WriteOut
+		cmd.Flags().StringVarP(&f.WriteOut, "write-out", "w", "", "Output variables like %{http_code} %{time_total} or %{json} after transfer, use @filename to read format from file")
+
 		// Version
 		cmd.Flags().BoolVarP(&f.Version, "version", "V", false, "Output version info")
 
@@ -142,7 +319,16 @@ func (f *Flags) RegisterForCommand(cmd *cobra.Command) {
 		cmd.Flags().StringVarP(&f.DumpHeader, "dump-header", "D", "", "Output response headers to file")
 
 		// Proxy
-		cmd.Flags().StringVarP(&f.Proxy, "proxy", "x", "", "Use proxy [protocol://]host[:port]")
+		{
+			cmd.Flags().StringVarP(&f.Proxy, "proxy", "x", "", "Use proxy [protocol://]host[:port], protocols: http, https, socks4, socks4a, socks5, socks5h")
+			cmd.Flags().StringVarP(&f.ProxyUser, "proxy-user", "U", "", "Proxy user and password <user:password>")
+			cmd.Flags().StringArrayVar(&f.ProxyHeader, "proxy-header", []string{}, "Header to send to proxy (key:value)")
+			cmd.Flags().StringVar(&f.NoProxy, "noproxy", "", "List of hosts which do not use proxy, separated by ',', use * for all hosts")
+			// -p 已被--pretty使用，--proxytunnel不提供短选项
+			cmd.Flags().BoolVar(&f.ProxyTunnel, "proxytunnel", false, "Operate through an HTTP proxy tunnel (using CONNECT) for plain http")
+			cmd.Flags().StringVar(&f.ProxyCACert, "proxy-cacert", "", "CA certificate file to verify HTTPS proxy against")
+			cmd.Flags().BoolVar(&f.ProxyInsecure, "proxy-insecure", false, "Do HTTPS proxy connections without verifying the proxy")
+		}
 
 		// Head
 		cmd.Flags().BoolVarP(&f.Head, "head", "I", false, "Default use head request, only print response headers")
@@ -159,17 +345,128 @@ func (f *Flags) RegisterForCommand(cmd *cobra.Command) {
 			cmd.Flags().BoolVarP(&f.Silent, "silent", "s", false, "Silent mode, discard all log")
 		}
 
+		// Progress
+		{
+			cmd.Flags().BoolVarP(&f.ProgressBar, "progress-bar", "#", false, "Display transfer progress as a bar")
+			cmd.Flags().BoolVar(&f.NoProgressMeter, "no-progress-meter", false, "Do not show the progress meter")
+		}
+
 		// Timeout 总超时时间
 		cmd.Flags().Float64VarP(&f.MaxTime, "max-time", "m", 0, "<fractional seconds> Maximum time allowed for http request")
 		// Connect timeout TCP连接超时时间
 		cmd.Flags().Float64Var(&f.ConnectTimeout, "connect-timeout", 30.0, "<fractional seconds> Maximum time allowed for connection")
+
+		// Name resolve
+		{
+			cmd.Flags().StringArrayVar(&f.Resolve, "resolve", []string{}, "Resolve the host+port to this address <host:port:addr[,addr]...>, use * as host to match any host")
+			cmd.Flags().StringArrayVar(&f.ConnectTo, "connect-to", []string{}, "Connect to HOST2:PORT2 instead of HOST1:PORT1 <HOST1:PORT1:HOST2:PORT2>")
+			cmd.Flags().BoolVarP(&f.IPv4, "ipv4", "4", false, "Resolve names to IPv4 addresses only")
+			cmd.Flags().BoolVarP(&f.IPv6, "ipv6", "6", false, "Resolve names to IPv6 addresses only")
+			cmd.Flags().StringSliceVar(&f.DNSServers, "dns-servers", []string{}, "DNS server addrs to use <addr[:port]>[,addr[:port]]...")
+			cmd.Flags().StringVar(&f.DoHURL, "doh-url", "", "Resolve host names over DNS-over-HTTPS")
+			cmd.MarkFlagsMutuallyExclusive("ipv4", "ipv6")
+		}
+
+		// HTTP version
+		{
+			cmd.Flags().BoolVarP(&f.HTTP10, "http1.0", "0", false, "Use HTTP 1.0")
+			cmd.Flags().BoolVar(&f.HTTP11, "http1.1", false, "Use HTTP 1.1")
+			cmd.Flags().BoolVar(&f.HTTP2, "http2", false, "Use HTTP/2, negotiated by ALPN for https, cleartext http still uses HTTP/1.1")
+			cmd.Flags().BoolVar(&f.HTTP2PriorKnowledge, "http2-prior-knowledge", false, "Use HTTP/2 with prior knowledge, cleartext http uses h2c")
+			cmd.Flags().BoolVar(&f.HTTP3, "http3", false, "Use HTTP/3, fallback to TCP if QUIC fails")
+			cmd.Flags().BoolVar(&f.HTTP3Only, "http3-only", false, "Use HTTP/3 only")
+			cmd.Flags().StringVar(&f.AltSvc, "alt-svc", "", "<file name> Enable alt-svc with this cache file")
+			cmd.MarkFlagsMutuallyExclusive("http1.0", "http1.1", "http2", "http2-prior-knowledge", "http3", "http3-only")
+			cmd.Flags().BoolVar(&f.RawHeaders, "raw-headers", false, "Send -H headers as given, keep case, order and duplicates, HTTP/1.x only")
+			cmd.Flags().StringVar(&f.RawRequest, "raw-request", "", "<file|-> Send the request in file verbatim to <host:port> or http(s) url")
+			for _, version := range []string{"http2", "http2-prior-knowledge", "http3", "http3-only"} {
+				cmd.MarkFlagsMutuallyExclusive("raw-headers", version)
+			}
+		}
+
+		// Local binding
+		{
+			cmd.Flags().StringVar(&f.Interface, "interface", "", "Use network interface <name|ip|host>, prefix if! or host! to force the type")
+			cmd.Flags().StringVar(&f.LocalPort, "local-port", "", "Force use of RANGE for local port numbers <N[-M]>")
+		}
+
+		// Unix socket
+		{
+			cmd.Flags().StringVar(&f.UnixSocket, "unix-socket", "", "Connect through this Unix domain socket <path>")
+			cmd.Flags().StringVar(&f.AbstractUnixSocket, "abstract-unix-socket", "", "Connect via abstract Unix domain socket <name>")
+			cmd.MarkFlagsMutuallyExclusive("unix-socket", "abstract-unix-socket")
+		}
+
+		// Rate limit
+		{
+			cmd.Flags().StringVar(&f.LimitRate, "limit-rate", "", "Limit transfer speed to RATE bytes per second, for example: 100K, 1M, 1G")
+			cmd.Flags().StringVar(&f.LimitRateUpload, "limit-rate-upload", "", "Limit upload speed to RATE, overrides --limit-rate")
+			cmd.Flags().StringVar(&f.LimitRateDownload, "limit-rate-download", "", "Limit download speed to RATE, overrides --limit-rate")
+		}
+
+		// Redirect
+		{
+			cmd.Flags().BoolVarP(&f.Location, "location", "L", false, "Follow redirects")
+			cmd.Flags().IntVar(&f.MaxRedirs, "max-redirs", 50, "Maximum number of redirects allowed, -1 for unlimited")
+			cmd.Flags().BoolVar(&f.Post301, "post301", false, "Do not switch to GET after following a 301")
+			cmd.Flags().BoolVar(&f.Post302, "post302", false, "Do not switch to GET after following a 302")
+			cmd.Flags().BoolVar(&f.Post303, "post303", false, "Do not switch to GET after following a 303")
+		}
+
+		// Retry
+		{
+			cmd.Flags().IntVar(&f.Retry, "retry", 0, "Retry request if transient problems occur")
+			cmd.Flags().Float64Var(&f.RetryDelay, "retry-delay", 0, "<fractional seconds> Wait time between retries, default use exponential backoff")
+			cmd.Flags().Float64Var(&f.RetryMaxTime, "retry-max-time", 0, "<fractional seconds> Retry only within this period")
+			cmd.Flags().BoolVar(&f.RetryAllErrors, "retry-all-errors", false, "Retry all errors (use with --retry)")
+			cmd.Flags().BoolVar(&f.RetryConnRefused, "retry-connrefused", false, "Retry on connection refused (use with --retry)")
+		}
+
+		// TLS
+		{
+			cmd.Flags().BoolVarP(&f.Insecure, "insecure", "k", false, "Allow insecure server connections when using SSL")
+			cmd.Flags().StringVar(&f.CACert, "cacert", "", "CA certificate file to verify peer against")
+			cmd.Flags().StringVar(&f.CAPath, "capath", "", "CA certificate directory to verify peer against")
+			cmd.Flags().StringVarP(&f.Cert, "cert", "E", "", "Client certificate file and password <file[:password]>")
+			cmd.Flags().StringVar(&f.CertType, "cert-type", "PEM", "Certificate type (PEM|P12)")
+			cmd.Flags().StringVar(&f.Key, "key", "", "Private key file, default read from --cert file")
+			cmd.Flags().StringVar(&f.Pass, "pass", "", "Pass phrase for the private key or PKCS#12 file")
+			cmd.Flags().BoolVar(&f.TLSv10, "tlsv1.0", false, "Use TLSv1.0 or greater")
+			cmd.Flags().BoolVar(&f.TLSv11, "tlsv1.1", false, "Use TLSv1.1 or greater")
+			cmd.Flags().BoolVar(&f.TLSv12, "tlsv1.2", false, "Use TLSv1.2 or greater")
+			cmd.Flags().BoolVar(&f.TLSv13, "tlsv1.3", false, "Use TLSv1.3 or greater")
+			cmd.Flags().StringVar(&f.TLSMax, "tls-max", "", "Set maximum allowed TLS version (1.0|1.1|1.2|1.3|default)")
+			cmd.Flags().StringVar(&f.Ciphers, "ciphers", "", "TLS 1.2 (and lower) ciphers to use, separated by ':'")
+			cmd.Flags().StringVar(&f.PinnedPubKey, "pinnedpubkey", "", "FILE or sha256//hashes public key to verify peer against, hashes separated by ';'")
+		}
+
+		// Auth
+		{
+			cmd.Flags().StringVarP(&f.User, "user", "u", "", "Server user and password <user:password>, also used as AK:SK for --aws-sigv4")
+			cmd.Flags().StringVar(&f.AwsSigV4, "aws-sigv4", "", "Use AWS V4 signature authentication <provider1[:provider2[:region[:service]]]>")
+			cmd.Flags().StringVar(&f.Sign, "sign", "", "Sign request with scheme <scheme>[:param], schemes: aws-sigv4, qbox, qiniu, hmac[:sha1|sha256|sha384|sha512], jwt[:HS256|HS384|HS512]")
+			cmd.Flags().StringVar(&f.Profile, "profile", "", "Profile in credentials file, default use $CURL_GO_PROFILE or default")
+			cmd.Flags().StringVar(&f.CredentialsFile, "credentials-file", "", "Credentials file in ini format, default ~/.curl-go/credentials")
+			cmd.Flags().StringVar(&f.JWTClaims, "jwt-claims", "", `Extra JWT claims in json, for example: {"aud":"api"}`)
+			cmd.Flags().Float64Var(&f.JWTExpire, "jwt-expire", 300, "<fractional seconds> JWT expire time")
+		}
+
+		// Cookie
+		{
+			cmd.Flags().StringVarP(&f.Cookie, "cookie", "b", "", `Send cookies from string "k=v; k2=v2" or read cookies from Netscape format file`)
+			cmd.Flags().StringVarP(&f.CookieJar, "cookie-jar", "c", "", "Write cookies to file after operation, use - for stdout")
+		}
 	}
 
 	// output pretty response json body
-	cmd.Flags().BoolVarP(&f.Pretty, "pretty", "p", false, "Output pretty response body, only for json response")
+	cmd.Flags().BoolVarP(&f.Pretty, "pretty", "p", false, "Output pretty response body, for json, ndjson, json-seq, bson and xml response")
+	cmd.Flags().BoolVar(&f.PrettyCompact, "pretty-compact", false, "Output each json record on one colorized line, implies -p")
 
 	// Content-MD5
 	cmd.Flags().BoolVar(&f.ContentMD5, "content-md5", false, "Auto calculate request body content md5 and add Content-MD5 header or trailer(if Transfer-Encoding:chunked)")
+	cmd.Flags().BoolVar(&f.VerifyDigest, "verify-digest", false, "Verify response body against Content-MD5, Content-Digest, Repr-Digest, x-amz-checksum or MD5 ETag, exit with 120 on mismatch")
+	cmd.Flags().BoolVar(&f.KeepBadOutput, "keep-bad-output", false, "Keep the output file when --verify-digest fails")
+	cmd.Flags().StringSliceVar(&f.Digest, "digest", []string{}, "Request body digests in header or trailer(if chunked or piped): md5, sha256, crc32c, content-sha-256, content-sha-512, repr-sha-256, repr-sha-512")
 
 	// http trailer
 	cmd.Flags().StringSliceVar(&f.Trailer, "trailer", []string{}, "Trailer (key:value)")
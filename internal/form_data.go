@@ -151,20 +151,32 @@ func (b *FormBodyBuilder) Build(formEntries []string) (err error) {
 }
 
 func BuildFormData(req *http.Request, formEntries []string) {
-	pipeReader, pipeWriter := io.Pipe()
-
-	multipartWriter := multipart.NewWriter(pipeWriter)
-	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
-	req.Body = pipeReader
-
-	// 后台逐步向pipeWriter中写入表单数据
-	go func() {
-		var err error
-		defer pipeWriter.CloseWithError(err)
-		defer multipartWriter.Close()
-		if err = (&FormBodyBuilder{w: multipartWriter}).Build(formEntries); err != nil {
-			log.Error("build & write multipart body error: ", err)
-			return
+	// 所有body共用同一个boundary，保证重放的body与Content-Type一致
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	newBody := func() (io.ReadCloser, error) {
+		pipeReader, pipeWriter := io.Pipe()
+
+		multipartWriter := multipart.NewWriter(pipeWriter)
+		if err := multipartWriter.SetBoundary(boundary); err != nil {
+			return nil, err
 		}
-	}()
+
+		// 后台逐步向pipeWriter中写入表单数据
+		go func() {
+			var err error
+			// Build结束时会关闭multipartWriter
+			defer func() { pipeWriter.CloseWithError(err) }()
+			if err = (&FormBodyBuilder{w: multipartWriter}).Build(formEntries); err != nil {
+				log.Error("build & write multipart body error: ", err)
+				return
+			}
+		}()
+		return pipeReader, nil
+	}
+
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	req.Body, _ = newBody()
+	// 重定向时需要重新生成body
+	req.GetBody = newBody
 }
@@ -0,0 +1,336 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+// h3FallbackHandshakeTimeout --http3时QUIC握手的超时时间，超时后回退到TCP
+const h3FallbackHandshakeTimeout = 3 * time.Second
+
+// h3Transport 对https请求尝试HTTP/3，--http3时失败回退到TCP，--http3-only时直接返回错误。
+// 未指定--http3时，响应中的Alt-Svc声明了h3后续请求也会尝试HTTP/3
+type h3Transport struct {
+	h3       *http3.RoundTripper
+	fallback http.RoundTripper
+	proxy    *proxyConfig
+	altSvc   *altSvcCache
+	mu       sync.Mutex
+	udp      *quic.Transport
+	broken   map[string]bool
+	forced   bool
+	only     bool
+	resolver *hostResolver
+}
+
+// buildHTTP3Transport 包装fallback，增加HTTP/3与Alt-Svc支持
+func buildHTTP3Transport(fallback http.RoundTripper, r *hostResolver, proxy *proxyConfig, tlsConfig *tls.Config, altSvc *altSvcCache) (*h3Transport, error) {
+	if (curlFlag.HTTP3 || curlFlag.HTTP3Only) && (curlFlag.UnixSocket != "" || curlFlag.AbstractUnixSocket != "") {
+		return nil, errors.New("HTTP/3 can not be used over unix socket")
+	}
+	t := &h3Transport{
+		fallback: fallback,
+		proxy:    proxy,
+		altSvc:   altSvc,
+		broken:   map[string]bool{},
+		forced:   curlFlag.HTTP3 || curlFlag.HTTP3Only,
+		only:     curlFlag.HTTP3Only,
+		resolver: r,
+	}
+	handshakeTimeout := time.Duration(curlFlag.ConnectTimeout * float64(time.Second))
+	if !t.only && (handshakeTimeout <= 0 || handshakeTimeout > h3FallbackHandshakeTimeout) {
+		handshakeTimeout = h3FallbackHandshakeTimeout
+	}
+	var cfg *tls.Config
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	t.h3 = &http3.RoundTripper{
+		TLSClientConfig: cfg,
+		QuicConfig:      &quic.Config{HandshakeIdleTimeout: handshakeTimeout},
+		Dial:            t.dial,
+	}
+	return t, nil
+}
+
+func (t *h3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := canonicalAddr(req.URL)
+	var resp *http.Response
+	var err error
+	if t.only && req.URL.Scheme != "https" {
+		// 与curl一致，重定向到http的请求同样不能回退到TCP
+		return nil, fmt.Errorf("HTTP/3 requested for non-HTTPS URL: %s", req.URL.Redacted())
+	}
+	if t.only && t.proxy != nil && !t.proxy.bypass(req.URL.Hostname()) {
+		return nil, errors.New("--http3-only can not be used with a proxy")
+	}
+	if t.only || t.useHTTP3(req, origin) {
+		resp, err = t.roundTripHTTP3(req)
+		if err != nil {
+			// 请求可能已经发出，回退会导致POST等请求被发送两次，只有连接失败时才回退
+			var dialErr *h3DialError
+			if t.only || !errors.As(err, &dialErr) {
+				return nil, err
+			}
+			log.Warnf("HTTP/3 to %s failed, fallback to TCP: %v", origin, err)
+			t.mu.Lock()
+			t.broken[origin] = true
+			t.mu.Unlock()
+			if req, err = rewindRequest(req); err != nil {
+				return nil, err
+			}
+			resp, err = t.fallback.RoundTrip(req)
+		}
+	} else {
+		resp, err = t.fallback.RoundTrip(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme == "https" {
+		t.altSvc.update(origin, resp)
+	}
+	return resp, nil
+}
+
+// useHTTP3 只有https请求可以使用HTTP/3，代理与失败过的origin使用TCP，
+// 显式指定了TCP上的协议版本时不会根据Alt-Svc升级
+func (t *h3Transport) useHTTP3(req *http.Request, origin string) bool {
+	if req.URL.Scheme != "https" || curlFlag.RawHeaders {
+		return false
+	}
+	if curlFlag.HTTP10 || curlFlag.HTTP11 || curlFlag.HTTP2 || curlFlag.HTTP2PriorKnowledge {
+		return false
+	}
+	if t.proxy != nil && !t.proxy.bypass(req.URL.Hostname()) {
+		return false
+	}
+	t.mu.Lock()
+	broken := t.broken[origin]
+	t.mu.Unlock()
+	if broken {
+		return false
+	}
+	if t.forced {
+		return true
+	}
+	_, ok := t.altSvc.lookup(origin)
+	return ok
+}
+
+// roundTripHTTP3 http3.RoundTripper 不触发trace事件，这里补充GetConn与GotFirstResponseByte
+func (t *h3Transport) roundTripHTTP3(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.GetConn != nil {
+		trace.GetConn(canonicalAddr(req.URL))
+	}
+	resp, err := t.h3.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	return resp, nil
+}
+
+// h3DialError QUIC连接或握手失败，此时请求还没有发出，可以安全地回退到TCP
+type h3DialError struct {
+	err error
+}
+
+func (e *h3DialError) Error() string { return e.err.Error() }
+func (e *h3DialError) Unwrap() error { return e.err }
+
+// dial 建立QUIC连接，失败时返回h3DialError
+func (t *h3Transport) dial(ctx context.Context, addr string, tlsConfig *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	conn, err := t.dialQUIC(ctx, addr, tlsConfig, cfg)
+	if err != nil {
+		return nil, &h3DialError{err: err}
+	}
+	return conn, nil
+}
+
+// dialQUIC addr为origin，优先连接Alt-Svc指定的地址，再处理--connect-to与--resolve
+func (t *h3Transport) dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	if authority, ok := t.altSvc.lookup(addr); ok {
+		log.Debugf("alt-svc: connect to %s for %s", authority, addr)
+		addr = authority
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	host, port = t.resolver.connectTo(host, port)
+
+	// net.Resolver会触发DNS相关的trace事件，--resolve指定的地址需要手动触发
+	trace := httptrace.ContextClientTrace(ctx)
+	if _, ok := t.resolver.overrides.lookup(host, port); !ok {
+		trace = nil
+	}
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	ips, err := t.resolver.lookup(ctx, host, port)
+	if trace != nil && trace.DNSDone != nil {
+		var addrs []net.IPAddr
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: ip})
+		}
+		trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	udp, err := t.udpTransport()
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, ip := range ips {
+		conn, err := t.dialAddr(ctx, udp, net.JoinHostPort(ip.String(), port), tlsConfig, cfg)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dialAddr 连接一个地址并等待握手完成，握手对应trace中的TLSHandshakeStart/TLSHandshakeDone
+func (t *h3Transport) dialAddr(ctx context.Context, udp *quic.Transport, addr string, tlsConfig *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("udp", addr)
+	}
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	conn, err := udp.DialEarly(ctx, raddr, tlsConfig, cfg)
+	if err == nil {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			err = context.Cause(conn.Context())
+		case <-ctx.Done():
+			conn.CloseWithError(0, "")
+			err = ctx.Err()
+		}
+	}
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("udp", addr, err)
+	}
+	if err != nil {
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tls.ConnectionState{}, err)
+		}
+		return nil, fmt.Errorf("quic: connect to %s: %w", addr, err)
+	}
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(conn.ConnectionState().TLS, nil)
+	}
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: &quicConnInfo{conn: conn}})
+	}
+	log.Debugf("quic: connected to %s from %s", conn.RemoteAddr(), conn.LocalAddr())
+	return conn, nil
+}
+
+// udpTransport 所有QUIC连接共用一个UDP socket
+func (t *h3Transport) udpTransport() (*quic.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.udp != nil {
+		return t.udp, nil
+	}
+	network := "udp"
+	switch {
+	case curlFlag.IPv4:
+		network = "udp4"
+	case curlFlag.IPv6:
+		network = "udp6"
+	}
+	laddr := &net.UDPAddr{}
+	binding, err := parseLocalBinding()
+	if err != nil {
+		return nil, err
+	}
+	if binding != nil {
+		laddr.IP, laddr.Port = binding.ip, binding.portFrom
+	}
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	t.udp = &quic.Transport{Conn: conn}
+	return t.udp, nil
+}
+
+// Close 关闭所有QUIC连接与共用的UDP socket，传输结束后调用
+func (t *h3Transport) Close() error {
+	err := t.h3.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.udp == nil {
+		return err
+	}
+	// quic.Transport不会关闭由调用方创建的Conn
+	err = errors.Join(err, t.udp.Close(), t.udp.Conn.Close())
+	t.udp = nil
+	return err
+}
+
+// quicConnInfo 用于GotConn事件，只提供QUIC连接的本地与远端地址
+type quicConnInfo struct {
+	net.Conn
+	conn quic.EarlyConnection
+}
+
+func (c *quicConnInfo) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConnInfo) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// canonicalAddr 返回url的host:port，省略端口时使用scheme的默认端口
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// rewindRequest 回退到TCP时需要重新读取请求body
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body can not be resent over TCP")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = body
+	return req, nil
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newH3Server 在同一个端口上启动TCP的TLS服务与QUIC服务，quic为false时只启动TCP服务
+func newH3Server(t *testing.T, handler http.Handler, quic bool) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	if !quic {
+		return srv.URL
+	}
+
+	u, _ := url.Parse(srv.URL)
+	conn, err := net.ListenPacket("udp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h3 := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(srv.TLS.Clone()),
+	}
+	go h3.Serve(conn)
+	t.Cleanup(func() {
+		h3.Close()
+		conn.Close()
+	})
+	return srv.URL
+}
+
+// newH3Client 与curl.go一致，用buildHTTP3Transport包装TCP的transport
+func newH3Client(t *testing.T) *http.Client {
+	t.Helper()
+	cfg, err := buildTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := buildHostResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial, err := buildDialContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &http.Transport{TLSClientConfig: cfg, ForceAttemptHTTP2: true, DialContext: dial}
+	t.Cleanup(transport.CloseIdleConnections)
+	rt, err := buildHTTP3Transport(buildTransport(transport), r, nil, cfg, loadAltSvcCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rt.Close() })
+	return &http.Client{Transport: rt}
+}
+
+// protoHandler 返回请求使用的协议，tcp中的Alt-Svc声明同一端口上的h3
+func protoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor < 3 {
+		_, port, _ := net.SplitHostPort(r.Host)
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%s"; ma=3600`, port))
+	}
+	io.WriteString(w, r.Proto)
+}
+
+func getProto(t *testing.T, c *http.Client, url string) string {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestHTTP3Only(t *testing.T) {
+	url := newH3Server(t, http.HandlerFunc(protoHandler), true)
+	setFlags(t, &Flags{Insecure: true, HTTP3Only: true})
+	if proto := getProto(t, newH3Client(t), url); proto != "HTTP/3.0" {
+		t.Fatalf("proto = %s, want HTTP/3.0", proto)
+	}
+
+	// http的url不能回退到TCP
+	plain := httptest.NewServer(http.HandlerFunc(protoHandler))
+	defer plain.Close()
+	if _, err := newH3Client(t).Get(plain.URL); err == nil {
+		t.Fatal("expect error with --http3-only for http url")
+	}
+}
+
+func TestHTTP3Fallback(t *testing.T) {
+	url := newH3Server(t, http.HandlerFunc(protoHandler), false)
+
+	setFlags(t, &Flags{Insecure: true, HTTP3: true, ConnectTimeout: 0.5})
+	if proto := getProto(t, newH3Client(t), url); proto == "HTTP/3.0" {
+		t.Fatalf("proto = %s, want fallback to TCP", proto)
+	}
+
+	setFlags(t, &Flags{Insecure: true, HTTP3Only: true, ConnectTimeout: 0.5})
+	if _, err := newH3Client(t).Get(url); err == nil {
+		t.Fatal("expect error with --http3-only when QUIC is unavailable")
+	}
+}
+
+// TestHTTP3NoFallbackAfterRequestSent 请求已经通过HTTP/3发出时不能回退，否则POST会发送两次
+func TestHTTP3NoFallbackAfterRequestSent(t *testing.T) {
+	var tcpRequests atomic.Int32
+	url := newH3Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 3 {
+			io.ReadAll(r.Body)
+			panic(http.ErrAbortHandler)
+		}
+		tcpRequests.Add(1)
+	}), true)
+
+	setFlags(t, &Flags{Insecure: true, HTTP3: true})
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := newH3Client(t).Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("expect error when HTTP/3 stream is aborted")
+	}
+	if n := tcpRequests.Load(); n != 0 {
+		t.Fatalf("request is resent over TCP %d times", n)
+	}
+}
+
+func TestAltSvcUpgrade(t *testing.T) {
+	url := newH3Server(t, http.HandlerFunc(protoHandler), true)
+
+	tests := []struct {
+		name    string
+		flags   *Flags
+		upgrade bool
+	}{
+		{"default", &Flags{}, true},
+		{"http1.0", &Flags{HTTP10: true}, false},
+		{"http1.1", &Flags{HTTP11: true}, false},
+		{"http2", &Flags{HTTP2: true}, false},
+		{"http2 prior knowledge", &Flags{HTTP2PriorKnowledge: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags.Insecure = true
+			setFlags(t, tt.flags)
+			c := newH3Client(t)
+			if proto := getProto(t, c, url); proto == "HTTP/3.0" {
+				t.Fatalf("first request proto = %s, want TCP", proto)
+			}
+			proto := getProto(t, c, url)
+			if upgraded := proto == "HTTP/3.0"; upgraded != tt.upgrade {
+				t.Fatalf("second request proto = %s, want upgrade %v", proto, tt.upgrade)
+			}
+		})
+	}
+}
+
+func TestParseAltSvc(t *testing.T) {
+	now := time.Now()
+	entries, clear := parseAltSvc(`h3=":443"; ma=60; persist=1, h2="alt.example.com:8443"`, now)
+	if clear {
+		t.Fatal("unexpected clear")
+	}
+	want := []altSvcEntry{
+		{protocol: "h3", authority: ":443", expires: now.Add(time.Minute), persist: true},
+		{protocol: "h2", authority: "alt.example.com:8443", expires: now.Add(24 * time.Hour)},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("entries = %+v, want %+v", entries, want)
+	}
+	if _, clear := parseAltSvc("clear", now); !clear {
+		t.Fatal("expect clear")
+	}
+}
+
+// TestAltSvcCacheFile alt-svc文件中记录origin实际使用的协议
+func TestAltSvcCacheFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "altsvc.txt")
+	setFlags(t, &Flags{AltSvc: file})
+	cache := loadAltSvcCache()
+	cache.update("example.com:443", &http.Response{
+		ProtoMajor: 1,
+		Header:     http.Header{"Alt-Svc": {`h3=":8443"; ma=3600`}},
+	})
+	cache.save()
+
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bs), "\nh1 example.com 443 h3 example.com 8443 ") {
+		t.Fatalf("alt-svc file:\n%s\nwant entry learned over h1", bs)
+	}
+	if authority, ok := loadAltSvcCache().lookup("example.com:443"); !ok || authority != "example.com:8443" {
+		t.Fatalf("lookup = %s, %v, want example.com:8443", authority, ok)
+	}
+}
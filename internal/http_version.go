@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+// buildTransport 按--http1.0、--http1.1、--http2、--http2-prior-knowledge配置协议版本
+func buildTransport(transport *http.Transport) http.RoundTripper {
+	var wrappers []func(context.Context, net.Conn) net.Conn
+	// --raw-headers 需要改写HTTP/1.x的请求头，先改写header再改写请求行。
+	// 禁用keep-alive后每个连接只发送一个请求，拨号的context即为该请求的context
+	if curlFlag.RawHeaders {
+		disableHTTP2(transport)
+		transport.DisableKeepAlives = true
+		wrappers = append(wrappers, func(ctx context.Context, conn net.Conn) net.Conn {
+			headers, _ := ctx.Value(rawHeadersKey{}).([]headerArg)
+			return &rawHeaderConn{Conn: conn, headers: headers}
+		})
+	}
+	var rt http.RoundTripper = transport
+	if curlFlag.RawHeaders {
+		rt = &rawHeaderTransport{transport: transport}
+	}
+	switch {
+	case curlFlag.HTTP10:
+		disableHTTP2(transport)
+		transport.DisableKeepAlives = true
+		wrappers = append(wrappers, func(_ context.Context, conn net.Conn) net.Conn {
+			return &http10Conn{Conn: conn}
+		})
+	case curlFlag.HTTP11:
+		disableHTTP2(transport)
+	case curlFlag.HTTP2PriorKnowledge:
+		dial := transport.DialContext
+		return &h2cTransport{
+			h2c: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dial(ctx, network, addr)
+				},
+			},
+			transport: transport,
+		}
+	case curlFlag.HTTP2:
+		// https通过ALPN只协商h2与http/1.1，明文http不支持Upgrade: h2c，仍使用HTTP/1.1
+		transport.ForceAttemptHTTP2 = true
+		cfg := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			cfg = transport.TLSClientConfig.Clone()
+		}
+		cfg.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		transport.TLSClientConfig = cfg
+		log.Debug("http2: HTTP/2 is negotiated by ALPN for https, use --http2-prior-knowledge for cleartext HTTP/2")
+	}
+	if len(wrappers) > 0 {
+		wrapConn(transport, wrappers)
+	}
+	return rt
+}
+
+// wrapConn 包装连接以改写写出的请求，wrappers中靠前的先处理写出的数据
+func wrapConn(transport *http.Transport, wrappers []func(context.Context, net.Conn) net.Conn) {
+	wrap := func(ctx context.Context, conn net.Conn) net.Conn {
+		for i := len(wrappers) - 1; i >= 0; i-- {
+			conn = wrappers[i](ctx, conn)
+		}
+		return conn
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ctx, conn), nil
+	}
+	// 请求需要在TLS之上改写，https由这里完成TLS握手
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn, err := handshakeTLS(ctx, conn, transport.TLSClientConfig, addr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return wrap(ctx, tlsConn), nil
+	}
+}
+
+func disableHTTP2(transport *http.Transport) {
+	transport.ForceAttemptHTTP2 = false
+	// 非nil的空map禁止http.Transport启用HTTP/2
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+}
+
+// handshakeTLS 自行完成TLS握手，http.Transport不会再触发TLS相关的trace事件，需要手动触发
+func handshakeTLS(ctx context.Context, conn net.Conn, config *tls.Config, addr string) (*tls.Conn, error) {
+	cfg := &tls.Config{}
+	if config != nil {
+		cfg = config.Clone()
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+	}
+	return tlsConn, err
+}
+
+// http10Conn 将http.Transport写出的请求行改为HTTP/1.0，每个连接只发送一个请求。
+// 请求行可能分多次写出，读取到完整的请求行后再改写
+type http10Conn struct {
+	net.Conn
+	buf       []byte
+	rewritten bool
+}
+
+func (c *http10Conn) Write(b []byte) (int, error) {
+	if c.rewritten {
+		return c.Conn.Write(b)
+	}
+	c.buf = append(c.buf, b...)
+	end := bytes.Index(c.buf, []byte("\r\n"))
+	if end < 0 {
+		return len(b), nil
+	}
+	c.rewritten = true
+	buf := c.buf
+	c.buf = nil
+	if line := buf[:end]; bytes.HasSuffix(line, []byte(" HTTP/1.1")) {
+		copy(line[len(line)-len("1.1"):], "1.0")
+	}
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// prepareHTTP10Request HTTP/1.0不支持chunked编码，长度未知的body需要读取到内存中计算长度
+func prepareHTTP10Request(req *http.Request) error {
+	if !curlFlag.HTTP10 {
+		return nil
+	}
+	if len(req.Trailer) > 0 || req.Header.Get("Transfer-Encoding") != "" {
+		return errors.New("--http1.0 does not support Transfer-Encoding or trailers")
+	}
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength > 0 {
+		return nil
+	}
+	bs, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body.Close()
+	req.ContentLength = int64(len(bs))
+	req.Body = io.NopCloser(bytes.NewReader(bs))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(bs)), nil
+	}
+	log.Debugf("http1.0: read request body into memory, length: %d", len(bs))
+	return nil
+}
+
+// h2cTransport --http2-prior-knowledge时http请求直接使用HTTP/2，https请求仍通过ALPN协商
+type h2cTransport struct {
+	h2c       *http2.Transport
+	transport *http.Transport
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// logProtocol 输出协商的协议版本
+func logProtocol(resp *http.Response) {
+	if resp.TLS != nil && resp.TLS.NegotiatedProtocol != "" {
+		log.Debugf("using %s, ALPN: %s", resp.Proto, resp.TLS.NegotiatedProtocol)
+		return
+	}
+	log.Debugf("using %s", resp.Proto)
+}
@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// jsonIndent 格式化输出的缩进
+const jsonIndent = "  "
+
+// jsonSeqRS json-seq中记录的起始字符
+const jsonSeqRS = 0x1E
+
+// jsonPrintOptions 格式化json的选项
+type jsonPrintOptions struct {
+	// style 为nil时不着色
+	style *pretty.Style
+	// compact 每个值输出为一行
+	compact bool
+	// seq RFC 7464 json-seq，每条记录以RS(0x1E)开头
+	seq bool
+}
+
+// jsonPrinter 逐个token格式化json，不解码字符串与数字，保留key的顺序、数字的原始文本与unicode转义，
+// 支持多个连续的json值。style中的颜色为空字符串时即不着色
+type jsonPrinter struct {
+	r      *bufio.Reader
+	w      *bufio.Writer
+	style  *pretty.Style
+	opts   jsonPrintOptions
+	offset int64
+}
+
+// jsonStreamType 判断是否为NDJSON、JSON Lines或json-seq，seq表示json-seq
+func jsonStreamType(contentType string) (stream, seq bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, false
+	}
+	switch mediaType {
+	case "application/json-seq":
+		return true, true
+	case "application/x-ndjson", "application/ndjson", "application/jsonl",
+		"application/x-jsonlines", "application/jsonlines", "application/json-lines":
+		return true, false
+	}
+	return false, false
+}
+
+// prettyPrintJSON 格式化r中所有的json值，每个值之后换行并立即输出，适用于NDJSON等持续输出的流
+func prettyPrintJSON(w io.Writer, r io.Reader, opts jsonPrintOptions) error {
+	style := opts.style
+	if style == nil {
+		style = &pretty.Style{}
+	}
+	p := &jsonPrinter{r: bufio.NewReader(r), w: bufio.NewWriter(w), style: style, opts: opts}
+	for {
+		c, err := p.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if c == jsonSeqRS && opts.seq {
+			continue
+		}
+		if err := p.value(c, 0); err != nil {
+			p.w.Flush()
+			return err
+		}
+		p.w.WriteByte('\n')
+		if err := p.w.Flush(); err != nil {
+			return err
+		}
+	}
+	return p.w.Flush()
+}
+
+// next 跳过空白，返回下一个字节
+func (p *jsonPrinter) next() (byte, error) {
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		p.offset++
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, nil
+	}
+}
+
+// expect 读取下一个非空白字节，EOF视为json不完整
+func (p *jsonPrinter) expect() (byte, error) {
+	c, err := p.next()
+	if err == io.EOF {
+		return 0, fmt.Errorf("invalid json: unexpected end of input at offset %d", p.offset)
+	}
+	return c, err
+}
+
+func (p *jsonPrinter) unexpected(c byte) error {
+	return fmt.Errorf("invalid json: unexpected %q at offset %d", c, p.offset)
+}
+
+func (p *jsonPrinter) write(color [2]string, s string) {
+	p.w.WriteString(color[0])
+	p.w.WriteString(s)
+	p.w.WriteString(color[1])
+}
+
+func (p *jsonPrinter) newline(depth int) {
+	if p.opts.compact {
+		return
+	}
+	p.w.WriteByte('\n')
+	p.w.WriteString(strings.Repeat(jsonIndent, depth))
+}
+
+// value 格式化以c开头的一个值
+func (p *jsonPrinter) value(c byte, depth int) error {
+	switch c {
+	case '{':
+		return p.container('{', '}', depth, func(c byte) error {
+			if c != '"' {
+				return p.unexpected(c)
+			}
+			key, err := p.string()
+			if err != nil {
+				return err
+			}
+			p.write(p.style.Key, key)
+			if c, err = p.expect(); err != nil {
+				return err
+			} else if c != ':' {
+				return p.unexpected(c)
+			}
+			if p.opts.compact {
+				p.w.WriteByte(':')
+			} else {
+				p.w.WriteString(": ")
+			}
+			if c, err = p.expect(); err != nil {
+				return err
+			}
+			return p.value(c, depth+1)
+		})
+	case '[':
+		return p.container('[', ']', depth, func(c byte) error {
+			return p.value(c, depth+1)
+		})
+	case '"':
+		s, err := p.string()
+		if err != nil {
+			return err
+		}
+		p.write(p.style.String, s)
+		return nil
+	default:
+		return p.literal(c)
+	}
+}
+
+// container 格式化对象或数组，element处理以c开头的一个元素
+func (p *jsonPrinter) container(start, end byte, depth int, element func(c byte) error) error {
+	p.write(p.style.Brackets, string(start))
+	c, err := p.expect()
+	if err != nil {
+		return err
+	}
+	if c == end {
+		p.write(p.style.Brackets, string(end))
+		return nil
+	}
+	for {
+		p.newline(depth + 1)
+		if err := element(c); err != nil {
+			return err
+		}
+		if c, err = p.expect(); err != nil {
+			return err
+		}
+		switch c {
+		case ',':
+			p.w.WriteByte(',')
+			if c, err = p.expect(); err != nil {
+				return err
+			}
+		case end:
+			p.newline(depth)
+			p.write(p.style.Brackets, string(end))
+			return nil
+		default:
+			return p.unexpected(c)
+		}
+	}
+}
+
+// string 原样读取一个字符串，包括两端的引号与转义
+func (p *jsonPrinter) string() (string, error) {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	escaped := false
+	for {
+		c, err := p.r.ReadByte()
+		if err == io.EOF {
+			return "", fmt.Errorf("invalid json: unterminated string at offset %d", p.offset)
+		} else if err != nil {
+			return "", err
+		}
+		p.offset++
+		sb.WriteByte(c)
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return sb.String(), nil
+		case c < ' ':
+			return "", p.unexpected(c)
+		}
+	}
+}
+
+// literal 原样读取数字、true、false或null
+func (p *jsonPrinter) literal(c byte) error {
+	var sb strings.Builder
+	sb.WriteByte(c)
+	for {
+		b, err := p.r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !isLiteralByte(b) {
+			p.r.UnreadByte()
+			break
+		}
+		p.offset++
+		sb.WriteByte(b)
+	}
+	token := sb.String()
+	switch token {
+	case "true":
+		p.write(p.style.True, token)
+	case "false":
+		p.write(p.style.False, token)
+	case "null":
+		p.write(p.style.Null, token)
+	default:
+		// json.Valid 用于校验数字格式，不会改变数字的文本
+		if !json.Valid([]byte(token)) {
+			return fmt.Errorf("invalid json: unexpected %q at offset %d", token, p.offset)
+		}
+		p.write(p.style.Number, token)
+	}
+	return nil
+}
+
+func isLiteralByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.'
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/pretty"
+)
+
+func TestPrettyPrintJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		opts    jsonPrintOptions
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "key order and big int",
+			input: `{"b":1,"a":1234567890123456789}`,
+			want:  "{\n  \"b\": 1,\n  \"a\": 1234567890123456789\n}\n",
+		},
+		{
+			name:  "number text",
+			input: `[1.0,1e3,-0.50]`,
+			want:  "[\n  1.0,\n  1e3,\n  -0.50\n]\n",
+		},
+		{
+			name:  "unicode escape",
+			input: `"\u00e9"`,
+			want:  "\"\\u00e9\"\n",
+		},
+		{
+			name:  "escaped quote",
+			input: `{"k\"":"v\\"}`,
+			want:  "{\n  \"k\\\"\": \"v\\\\\"\n}\n",
+		},
+		{
+			name:  "multiple values",
+			input: `1 2 {}`,
+			want:  "1\n2\n{}\n",
+		},
+		{
+			name:  "nested empty containers",
+			input: `{"a":[],"b":{},"c":[null,true,false]}`,
+			want:  "{\n  \"a\": [],\n  \"b\": {},\n  \"c\": [\n    null,\n    true,\n    false\n  ]\n}\n",
+		},
+		{
+			name:  "color",
+			input: `{"a":true}`,
+			opts:  jsonPrintOptions{style: &pretty.Style{Key: [2]string{"<", ">"}, True: [2]string{"[", "]"}}},
+			want:  "{\n  <\"a\">: [true]\n}\n",
+		},
+		{name: "invalid literal", input: `truex`, wantErr: true},
+		{name: "invalid number", input: `[01]`, wantErr: true},
+		{name: "unterminated string", input: `{"a":"b`, wantErr: true},
+		{name: "unterminated object", input: `{"a":1`, wantErr: true},
+		{name: "missing colon", input: `{"a" 1}`, wantErr: true},
+		{name: "trailing comma", input: `[1,]`, wantErr: true},
+		{name: "non-string key", input: `{1:2}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			err := prettyPrintJSON(&sb, strings.NewReader(tt.input), tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expect error, got output %q", sb.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.String(); got != tt.want {
+				t.Fatalf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+var spinnerFrames = []string{"-", "\\", "|", "/"}
+
+// Progress 在标准错误输出上展示上传与下载进度，nil表示不展示
+type Progress struct {
+	bar bool
+	w   io.Writer
+
+	uploadTotal   atomic.Int64
+	uploaded      atomic.Int64
+	downloadTotal atomic.Int64
+	downloaded    atomic.Int64
+
+	start    time.Time
+	lastTime time.Time
+	lastSize int64
+	speed    float64
+	frame    int
+
+	mu       sync.Mutex
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// newProgress 按--progress-bar、--no-progress-meter、-s等flags创建进度展示，不需要展示时返回nil
+func newProgress() *Progress {
+	if curlFlag.Silent || curlFlag.NoProgressMeter || curlFlag.Head {
+		return nil
+	}
+	if !curlFlag.ProgressBar && curlFlag.OutputFile == "" {
+		// 与curl一致，响应输出到终端时不展示默认的进度
+		if stat, err := os.Stdout.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			return nil
+		}
+	}
+	return &Progress{
+		bar:     curlFlag.ProgressBar,
+		w:       os.Stderr,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start 开始定时刷新进度
+func (p *Progress) Start() {
+	if p == nil {
+		return
+	}
+	p.start = time.Now()
+	p.lastTime = p.start
+	if !p.bar {
+		fmt.Fprintln(p.w, "  % Total    % Received % Xferd  Average Speed   Time    Time     Time  Current")
+		fmt.Fprintln(p.w, "                                 Dload  Upload   Total   Spent    Left  Speed")
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.render(false)
+			case <-p.done:
+				p.render(true)
+				return
+			}
+		}
+	}()
+}
+
+// Stop 输出最终进度并换行
+func (p *Progress) Stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		close(p.done)
+		<-p.stopped
+		fmt.Fprintln(p.w)
+	})
+}
+
+type progressReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// WrapRequest 统计请求body的上传进度，重试或重定向重新生成body时从0开始统计
+func (p *Progress) WrapRequest(req *http.Request) {
+	if p == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	if req.ContentLength > 0 {
+		p.uploadTotal.Store(req.ContentLength)
+	}
+	req.Body = &progressReader{ReadCloser: req.Body, n: &p.uploaded}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			p.uploaded.Store(0)
+			return &progressReader{ReadCloser: body, n: &p.uploaded}, nil
+		}
+	}
+}
+
+// WrapDownload 统计响应body的下载进度，total为整个文件的大小，未知时传入-1
+func (p *Progress) WrapDownload(body io.ReadCloser, total int64) io.ReadCloser {
+	if p == nil || body == nil {
+		return body
+	}
+	if total > 0 {
+		p.downloadTotal.Store(total)
+	}
+	return &progressReader{ReadCloser: body, n: &p.downloaded}
+}
+
+// formatProgressSize 与curl一致，使用不超过5个字符表示大小
+func formatProgressSize(n float64) string {
+	const (
+		k = 1024
+		m = 1024 * k
+		g = 1024 * m
+		t = 1024 * g
+	)
+	switch {
+	case n < 100000:
+		return fmt.Sprintf("%d", int64(n))
+	case n < 10000*k:
+		return fmt.Sprintf("%dk", int64(n/k))
+	case n < 100*m:
+		return fmt.Sprintf("%.1fM", n/m)
+	case n < 10000*m:
+		return fmt.Sprintf("%dM", int64(n/m))
+	case n < 100*g:
+		return fmt.Sprintf("%.1fG", n/g)
+	case n < 10000*g:
+		return fmt.Sprintf("%dG", int64(n/g))
+	default:
+		return fmt.Sprintf("%dT", int64(n/t))
+	}
+}
+
+func formatProgressTime(d time.Duration, known bool) string {
+	if !known || d < 0 {
+		return "--:--:--"
+	}
+	s := int64(d.Seconds())
+	if s > 99*3600 {
+		return fmt.Sprintf("%dd %02dh", s/86400, s%86400/3600)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s%3600/60, s%60)
+}
+
+func percent(done, total int64) string {
+	if total <= 0 {
+		return "  0"
+	}
+	return fmt.Sprintf("%3d", done*100/total)
+}
+
+func (p *Progress) render(final bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	up, down := p.uploaded.Load(), p.downloaded.Load()
+	upTotal, downTotal := p.uploadTotal.Load(), p.downloadTotal.Load()
+	spent := now.Sub(p.start)
+
+	// 当前速度使用两次刷新间的增量计算
+	if dt := now.Sub(p.lastTime).Seconds(); dt > 0 {
+		p.speed = float64(up+down-p.lastSize) / dt
+	}
+	p.lastTime, p.lastSize = now, up+down
+	p.frame++
+
+	var avgDown, avgUp float64
+	if secs := spent.Seconds(); secs > 0 {
+		avgDown, avgUp = float64(down)/secs, float64(up)/secs
+	}
+	known := (upTotal > 0 || up == 0) && (downTotal > 0 || down == 0) && upTotal+downTotal > 0
+	total, done := upTotal+downTotal, up+down
+	var left, totalTime time.Duration
+	if known && avgDown+avgUp > 0 {
+		left = time.Duration(float64(total-done) / (avgDown + avgUp) * float64(time.Second))
+		totalTime = spent + left
+	}
+
+	if p.bar {
+		p.renderBar(done, total, known, left, final)
+		return
+	}
+
+	totalPercent := percent(done, total)
+	if !known {
+		// 大小未知时展示转动的标记，传输结束时视为已完成
+		totalPercent = "  " + spinnerFrames[p.frame%len(spinnerFrames)]
+		if final {
+			totalPercent, total = "100", done
+		}
+	}
+	fmt.Fprintf(p.w, "\r%s %5s %s %5s %s %5s  %5s  %5s %s %s %s %5s",
+		totalPercent, formatProgressSize(float64(total)),
+		percent(down, downTotal), formatProgressSize(float64(down)),
+		percent(up, upTotal), formatProgressSize(float64(up)),
+		formatProgressSize(avgDown), formatProgressSize(avgUp),
+		formatProgressTime(totalTime, known && totalTime > 0),
+		formatProgressTime(spent, true),
+		formatProgressTime(left, known && totalTime > 0),
+		formatProgressSize(p.speed),
+	)
+}
+
+func (p *Progress) renderBar(done, total int64, known bool, left time.Duration, final bool) {
+	const width = 50
+	speed := formatProgressSize(p.speed) + "/s"
+	if !known {
+		// 大小未知时展示来回移动的标记
+		pos := p.frame % (2 * width)
+		if pos >= width {
+			pos = 2*width - pos - 1
+		}
+		line := []byte(strings.Repeat(" ", width))
+		line[pos] = '#'
+		if final {
+			line = []byte(strings.Repeat("#", width))
+		}
+		fmt.Fprintf(p.w, "\r%s %8s %9s", line, formatProgressSize(float64(done)), speed)
+		return
+	}
+	ratio := float64(done) / float64(total)
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * width)
+	fmt.Fprintf(p.w, "\r%s%s %5.1f%% %9s ETA %s",
+		strings.Repeat("#", filled), strings.Repeat(" ", width-filled),
+		ratio*100, speed, formatProgressTime(left, true))
+}
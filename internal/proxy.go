@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// proxyConfig --proxy等flags指定的代理，nil表示不使用代理
+type proxyConfig struct {
+	url       *url.URL
+	user      *url.Userinfo
+	header    http.Header
+	tlsConfig *tls.Config
+	noProxy   []string
+}
+
+// proxyFromEnvironment 与curl一致，按目标url的scheme读取代理环境变量，http_proxy只支持小写
+func proxyFromEnvironment(scheme string) string {
+	names := []string{scheme + "_proxy"}
+	if scheme != "http" {
+		names = append(names, strings.ToUpper(scheme)+"_PROXY")
+	}
+	names = append(names, "all_proxy", "ALL_PROXY")
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			log.Debugf("use proxy from environment %s: %s", name, v)
+			return v
+		}
+	}
+	return ""
+}
+
+// buildProxy 根据--proxy或代理环境变量创建代理配置，环境变量按初始请求的scheme选择
+func buildProxy(target *url.URL) (*proxyConfig, error) {
+	proxyStr := curlFlag.Proxy
+	if proxyStr == "" {
+		proxyStr = proxyFromEnvironment(target.Scheme)
+	}
+	if proxyStr == "" {
+		return nil, nil
+	}
+	if !strings.Contains(proxyStr, "://") {
+		proxyStr = "http://" + proxyStr
+	}
+	u, err := url.Parse(proxyStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %s", proxyStr)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch u.Scheme {
+	case "http", "https", "socks4", "socks4a", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if u.Port() == "" {
+		// 与curl一致，未指定端口时https代理使用443，其他代理使用1080
+		port := "1080"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	p := &proxyConfig{url: u, user: u.User, header: http.Header{}}
+	if curlFlag.ProxyUser != "" {
+		username, password, _ := strings.Cut(curlFlag.ProxyUser, ":")
+		p.user = url.UserPassword(username, password)
+	}
+	for _, h := range curlFlag.ProxyHeader {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid proxy header: %s", h)
+		}
+		p.header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+
+	noProxy := curlFlag.NoProxy
+	if noProxy == "" {
+		if noProxy = os.Getenv("no_proxy"); noProxy == "" {
+			noProxy = os.Getenv("NO_PROXY")
+		}
+	}
+	for _, host := range strings.Split(noProxy, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			p.noProxy = append(p.noProxy, host)
+		}
+	}
+
+	if u.Scheme == "https" {
+		pool, err := loadCertPool(curlFlag.ProxyCACert, "")
+		if err != nil {
+			return nil, err
+		}
+		p.tlsConfig = &tls.Config{
+			ServerName:         u.Hostname(),
+			RootCAs:            pool,
+			InsecureSkipVerify: curlFlag.ProxyInsecure,
+		}
+	}
+	log.Debugf("use proxy: %s", u.Redacted())
+	return p, nil
+}
+
+// bypass 判断host是否命中--noproxy或NO_PROXY，支持*、域名后缀、IP与CIDR
+func (p *proxyConfig) bypass(host string) bool {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, entry := range p.noProxy {
+		switch {
+		case entry == "*":
+			return true
+		case ip != nil:
+			if _, ipNet, err := net.ParseCIDR(entry); err == nil && ipNet.Contains(ip) {
+				return true
+			}
+			if entryIP := net.ParseIP(strings.Trim(entry, "[]")); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+		default:
+			entry = strings.TrimPrefix(entry, ".")
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isForwardProxy 明文http请求经http(s)代理转发时使用absolute-form请求，其他情况均通过隧道连接
+func (p *proxyConfig) isForwardProxy(u *url.URL) bool {
+	if p == nil || curlFlag.ProxyTunnel || u.Scheme != "http" || p.bypass(u.Hostname()) {
+		return false
+	}
+	return p.url.Scheme == "http" || p.url.Scheme == "https"
+}
+
+// forRequest 作为http.Transport.Proxy，只有absolute-form请求交给http.Transport处理。
+// https代理的TLS由wrapDial处理，因此这里返回http scheme
+func (p *proxyConfig) forRequest(req *http.Request) (*url.URL, error) {
+	if !p.isForwardProxy(req.URL) {
+		return nil, nil
+	}
+	u := *p.url
+	u.Scheme = "http"
+	u.User = p.user
+	return &u, nil
+}
+
+// wrapTransport 未指定--proxy-header时不需要包装
+func (p *proxyConfig) wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if p == nil || len(p.header) == 0 {
+		return rt
+	}
+	return &proxyHeaderTransport{transport: rt, proxy: p}
+}
+
+// proxyHeaderTransport absolute-form请求直接发送给代理，--proxy-header需要添加到请求中。
+// 每次请求单独判断，重定向到https或--noproxy的主机时不会把代理的header发送给目标服务
+type proxyHeaderTransport struct {
+	transport http.RoundTripper
+	proxy     *proxyConfig
+}
+
+func (t *proxyHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.proxy.isForwardProxy(req.URL) {
+		return t.transport.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, vs := range t.proxy.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+			log.Tracef("add proxy header: %s: %s", k, v)
+		}
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// wrapDial 返回经代理建立连接的函数，r用于socks4与socks5在本地解析目标地址
+func (p *proxyConfig) wrapDial(dial dialContextFunc, r *hostResolver) dialContextFunc {
+	if p == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if p.bypass(host) {
+			log.Debugf("bypass proxy for %s", host)
+			return dial(ctx, network, addr)
+		}
+		// 隧道的目标地址同样适用--connect-to
+		if host, port = r.connectTo(host, port); addr != p.url.Host {
+			addr = net.JoinHostPort(host, port)
+		}
+
+		conn, err := dial(ctx, network, p.url.Host)
+		if err != nil {
+			return nil, fmt.Errorf("connect to proxy %s error: %w", p.url.Host, err)
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		if conn, err = p.handshake(ctx, conn, addr, host, port, r); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+}
+
+func (p *proxyConfig) handshake(ctx context.Context, conn net.Conn, addr, host, port string, r *hostResolver) (net.Conn, error) {
+	switch p.url.Scheme {
+	case "socks4", "socks5":
+		// 在本地解析目标地址
+		if net.ParseIP(host) == nil {
+			ips, err := r.lookup(ctx, host, port)
+			if err != nil {
+				return conn, err
+			}
+			host = ips[0].String()
+		}
+		if p.url.Scheme == "socks4" {
+			return conn, socks4Connect(conn, host, port, p.user, false)
+		}
+		return conn, socks5Connect(conn, host, port, p.user)
+	case "socks4a":
+		return conn, socks4Connect(conn, host, port, p.user, true)
+	case "socks5h":
+		return conn, socks5Connect(conn, host, port, p.user)
+	}
+
+	if p.tlsConfig != nil {
+		tlsConn := tls.Client(conn, p.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return conn, fmt.Errorf("proxy tls handshake error: %w", err)
+		}
+		conn = tlsConn
+	}
+	// http.Transport连接代理后自行发送absolute-form请求
+	if addr == p.url.Host {
+		return conn, nil
+	}
+	return conn, p.connect(conn, addr)
+}
+
+// connect 发送CONNECT请求建立隧道，--proxy-header只在CONNECT请求中发送。
+// 隧道由wrapDial建立而不是http.Transport，因此不使用http.Transport.ProxyConnectHeader
+func (p *proxyConfig) connect(conn net.Conn, addr string) error {
+	log.Debugf("establish HTTP proxy tunnel to %s", addr)
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: p.header.Clone(),
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", curlFlag.UserAgent)
+	}
+	if p.user != nil {
+		password, _ := p.user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(p.user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fmt.Errorf("read proxy CONNECT response error: %w", err)
+	}
+	resp.Body.Close()
+	log.Debugf("proxy CONNECT response: %s", resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy CONNECT aborted: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return errors.New("proxy sent unexpected data after CONNECT response")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// socks4Connect 发送SOCKS4 CONNECT请求，socks4a时由代理解析host
+func socks4Connect(conn net.Conn, host, portStr string, user *url.Userinfo, remoteResolve bool) error {
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+	req := []byte{0x04, 0x01}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	ip := net.ParseIP(host).To4()
+	switch {
+	case ip != nil:
+		req = append(req, ip...)
+	case remoteResolve:
+		// SOCKS4a 使用0.0.0.x表示由代理解析域名
+		req = append(req, 0, 0, 0, 1)
+	default:
+		return fmt.Errorf("socks4 only supports IPv4 address: %s", host)
+	}
+	if user != nil {
+		req = append(req, user.Username()...)
+	}
+	req = append(req, 0)
+	if ip == nil {
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+	log.Debugf("socks4 connect to %s", net.JoinHostPort(host, portStr))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 8)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("read socks4 response error: %w", err)
+	}
+	if resp[1] != 0x5a {
+		return fmt.Errorf("socks4 request rejected, code: 0x%02x", resp[1])
+	}
+	return nil
+}
+
+var socks5Errors = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Connect 发送SOCKS5 CONNECT请求，host不是IP时由代理解析，指定用户时使用用户名密码认证
+func socks5Connect(conn net.Conn, host, portStr string, user *url.Userinfo) error {
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	methods := []byte{0x00}
+	if user != nil {
+		methods = append(methods, 0x02)
+	}
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("read socks5 greeting response error: %w", err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("invalid socks5 version: %d", reply[0])
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if err := socks5Auth(conn, user); err != nil {
+			return err
+		}
+	default:
+		return errors.New("socks5 proxy has no acceptable authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long for socks5: %s", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	log.Debugf("socks5 connect to %s", net.JoinHostPort(host, portStr))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("read socks5 response error: %w", err)
+	}
+	if resp[1] != 0x00 {
+		if msg, ok := socks5Errors[resp[1]]; ok {
+			return fmt.Errorf("socks5 request failed: %s", msg)
+		}
+		return fmt.Errorf("socks5 request failed, code: 0x%02x", resp[1])
+	}
+	// 读取并丢弃BND.ADDR与BND.PORT
+	var addrLen int
+	switch resp[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return fmt.Errorf("invalid socks5 address type: %d", resp[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// socks5Auth RFC 1929 用户名密码认证
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	if user == nil {
+		return errors.New("socks5 proxy requires authentication, use --proxy-user")
+	}
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("socks5 username or password too long")
+	}
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("read socks5 auth response error: %w", err)
+	}
+	if resp[1] != 0x00 {
+		return errors.New("socks5 authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testProxy 进程内的SOCKS与HTTP代理，记录每次请求的目标地址与认证信息。
+// 目标host为*.test时连接到127.0.0.1
+type testProxy struct {
+	mu     sync.Mutex
+	method string
+	target string
+	auth   string
+	header http.Header
+	// user 不为空时要求认证，socks5为 user:password，http代理为Basic认证的值
+	user string
+}
+
+func (p *testProxy) record(method, target, auth string, header http.Header) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.method, p.target, p.auth, p.header = method, target, auth, header
+}
+
+func (p *testProxy) last() (target, auth string, header http.Header) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.target, p.auth, p.header
+}
+
+func (p *testProxy) lastMethod() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.method
+}
+
+func (p *testProxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		host = "127.0.0.1"
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, net.JoinHostPort(host, port))
+}
+
+// serveSOCKS 在本地端口提供SOCKS4/4a/5服务，返回监听地址
+func (p *testProxy) serveSOCKS(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handleSOCKS(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (p *testProxy) handleSOCKS(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	version, err := br.ReadByte()
+	if err != nil {
+		return
+	}
+	var target, auth string
+	switch version {
+	case 0x04:
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(br, head); err != nil {
+			return
+		}
+		port := binary.BigEndian.Uint16(head[1:3])
+		ip := net.IP(head[3:7])
+		userID, _ := br.ReadString(0)
+		auth = userID[:len(userID)-1]
+		host := ip.String()
+		if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+			// SOCKS4a 由代理解析域名
+			domain, _ := br.ReadString(0)
+			host = domain[:len(domain)-1]
+		}
+		target = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		conn.Write([]byte{0x00, 0x5a, 0, 0, 0, 0, 0, 0})
+	case 0x05:
+		n, _ := br.ReadByte()
+		methods := make([]byte, n)
+		if _, err := io.ReadFull(br, methods); err != nil {
+			return
+		}
+		if p.user == "" {
+			conn.Write([]byte{0x05, 0x00})
+		} else {
+			conn.Write([]byte{0x05, 0x02})
+			head := make([]byte, 2)
+			io.ReadFull(br, head)
+			username := make([]byte, head[1])
+			io.ReadFull(br, username)
+			l, _ := br.ReadByte()
+			password := make([]byte, l)
+			io.ReadFull(br, password)
+			auth = string(username) + ":" + string(password)
+			if auth != p.user {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(br, head); err != nil {
+			return
+		}
+		var host string
+		switch head[3] {
+		case 0x01:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(br, ip)
+			host = net.IP(ip).String()
+		case 0x04:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(br, ip)
+			host = net.IP(ip).String()
+		case 0x03:
+			l, _ := br.ReadByte()
+			domain := make([]byte, l)
+			io.ReadFull(br, domain)
+			host = string(domain)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(br, port)
+		target = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	default:
+		return
+	}
+	p.record("", target, auth, nil)
+
+	upstream, err := p.dial(context.Background(), "tcp", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go io.Copy(upstream, br)
+	io.Copy(conn, upstream)
+}
+
+// ServeHTTP HTTP代理，支持CONNECT隧道与absolute-form请求的转发
+func (p *testProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if p.user != "" && auth != "Basic "+base64.StdEncoding.EncodeToString([]byte(p.user)) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method != http.MethodConnect {
+		p.record(r.Method, r.URL.Host, auth, r.Header.Clone())
+		transport := &http.Transport{DialContext: p.dial}
+		defer transport.CloseIdleConnections()
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := transport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, vs := range resp.Header {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	p.record(r.Method, r.Host, auth, r.Header.Clone())
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	conn, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go io.Copy(upstream, brw)
+	io.Copy(conn, upstream)
+}
+
+// proxyGet 按flags创建代理与连接函数并请求url，返回目标服务收到的Host
+func proxyGet(t *testing.T, rawURL string) (string, error) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := buildProxy(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := buildHostResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial, err := buildDialContext(r, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &http.Transport{Proxy: proxy.forRequest, DialContext: dial}
+	defer transport.CloseIdleConnections()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与transfer一致，由doRequest按-L跟随重定向
+	c := &http.Client{
+		Transport: proxy.wrapTransport(transport),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := doRequest(c, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	host, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("response status code: %d", resp.StatusCode)
+	}
+	return string(host), nil
+}
+
+func newTargetServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return srv, u.Port()
+}
+
+func TestSOCKSProxy(t *testing.T) {
+	_, port := newTargetServer(t)
+	target := "backend.test:" + port
+
+	tests := []struct {
+		name       string
+		scheme     string
+		user       string
+		flags      *Flags
+		wantTarget string
+		wantAuth   string
+	}{
+		{"socks4 resolves locally", "socks4", "", &Flags{}, "127.0.0.1:" + port, ""},
+		{"socks4a resolves remotely", "socks4a", "", &Flags{}, target, ""},
+		{"socks4 user id", "socks4a", "", &Flags{ProxyUser: "alice"}, target, "alice"},
+		{"socks5 resolves locally", "socks5", "", &Flags{}, "127.0.0.1:" + port, ""},
+		{"socks5h resolves remotely", "socks5h", "", &Flags{}, target, ""},
+		{"socks5h with auth", "socks5h", "alice:secret", &Flags{ProxyUser: "alice:secret"}, target, "alice:secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testProxy{user: tt.user}
+			addr := p.serveSOCKS(t)
+			tt.flags.Proxy = tt.scheme + "://" + addr
+			// 本地解析时使用--resolve，远程解析时代理收到的是域名
+			tt.flags.Resolve = []string{target + ":127.0.0.1"}
+			setFlags(t, tt.flags)
+
+			host, err := proxyGet(t, "http://"+target+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host != target {
+				t.Fatalf("Host = %s, want %s", host, target)
+			}
+			gotTarget, gotAuth, _ := p.last()
+			if gotTarget != tt.wantTarget || gotAuth != tt.wantAuth {
+				t.Fatalf("proxy got target %s auth %q, want %s %q", gotTarget, gotAuth, tt.wantTarget, tt.wantAuth)
+			}
+		})
+	}
+
+	t.Run("socks5 auth failed", func(t *testing.T) {
+		p := &testProxy{user: "alice:secret"}
+		setFlags(t, &Flags{Proxy: "socks5h://" + p.serveSOCKS(t), ProxyUser: "alice:wrong"})
+		if _, err := proxyGet(t, "http://"+target+"/"); err == nil {
+			t.Fatal("expect authentication error")
+		}
+	})
+}
+
+func TestHTTPProxy(t *testing.T) {
+	_, port := newTargetServer(t)
+	target := "backend.test:" + port
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+
+	tests := []struct {
+		name        string
+		tls         bool
+		flags       *Flags
+		wantConnect bool
+	}{
+		{"forward", false, &Flags{}, false},
+		{"proxytunnel", false, &Flags{ProxyTunnel: true}, true},
+		{"https proxy", true, &Flags{ProxyInsecure: true}, false},
+		{"https proxy tunnel", true, &Flags{ProxyInsecure: true, ProxyTunnel: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testProxy{user: "alice:secret"}
+			var srv *httptest.Server
+			if tt.tls {
+				srv = httptest.NewTLSServer(p)
+			} else {
+				srv = httptest.NewServer(p)
+			}
+			defer srv.Close()
+			tt.flags.Proxy = srv.URL
+			tt.flags.ProxyUser = "alice:secret"
+			tt.flags.ProxyHeader = []string{"X-Proxy-Token: abc"}
+			setFlags(t, tt.flags)
+
+			host, err := proxyGet(t, "http://"+target+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host != target {
+				t.Fatalf("Host = %s, want %s", host, target)
+			}
+			gotTarget, gotAuth, header := p.last()
+			if gotTarget != target || gotAuth != basic {
+				t.Fatalf("proxy got target %s auth %q, want %s %q", gotTarget, gotAuth, target, basic)
+			}
+			if header.Get("X-Proxy-Token") != "abc" {
+				t.Fatalf("proxy header X-Proxy-Token = %q, want abc", header.Get("X-Proxy-Token"))
+			}
+			if isConnect := p.lastMethod() == http.MethodConnect; isConnect != tt.wantConnect {
+				t.Fatalf("CONNECT = %v, want %v", isConnect, tt.wantConnect)
+			}
+		})
+	}
+
+	t.Run("https proxy untrusted", func(t *testing.T) {
+		srv := httptest.NewTLSServer(&testProxy{})
+		defer srv.Close()
+		setFlags(t, &Flags{Proxy: srv.URL})
+		if _, err := proxyGet(t, "http://"+target+"/"); err == nil {
+			t.Fatal("expect certificate error without --proxy-insecure")
+		}
+	})
+
+	t.Run("https proxy cacert", func(t *testing.T) {
+		srv := httptest.NewTLSServer(&testProxy{})
+		defer srv.Close()
+		setFlags(t, &Flags{Proxy: srv.URL, ProxyCACert: writeTempFile(t, "proxy-ca.pem", serverCertPEM(srv))})
+		if _, err := proxyGet(t, "http://"+target+"/"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("proxy auth required", func(t *testing.T) {
+		srv := httptest.NewServer(&testProxy{user: "alice:secret"})
+		defer srv.Close()
+		setFlags(t, &Flags{Proxy: srv.URL})
+		if _, err := proxyGet(t, "http://"+target+"/"); err == nil {
+			t.Fatal("expect 407 without --proxy-user")
+		}
+	})
+}
+
+// TestProxyHeaderRedirect --proxy-header只发送给代理，重定向到不经过代理的主机时不能发送给目标服务
+func TestProxyHeaderRedirect(t *testing.T) {
+	var leaked atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked.Store(r.Header.Get("X-Proxy-Token"))
+		io.WriteString(w, r.Host)
+	}))
+	defer origin.Close()
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, origin.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+	u, _ := url.Parse(redirector.URL)
+
+	p := &testProxy{}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	setFlags(t, &Flags{Proxy: srv.URL, NoProxy: "127.0.0.1", ProxyHeader: []string{"X-Proxy-Token: abc"}, Location: true, MaxRedirs: -1})
+
+	if _, err := proxyGet(t, "http://redirect.test:"+u.Port()+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, header := p.last(); header.Get("X-Proxy-Token") != "abc" {
+		t.Fatalf("proxy header X-Proxy-Token = %q, want abc", header.Get("X-Proxy-Token"))
+	}
+	if got := leaked.Load(); got != "" {
+		t.Fatalf("origin got proxy header X-Proxy-Token = %q", got)
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	_, port := newTargetServer(t)
+	p := &testProxy{}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	setFlags(t, &Flags{Proxy: srv.URL, NoProxy: "example.com,127.0.0.0/8"})
+
+	if _, err := proxyGet(t, "http://127.0.0.1:"+port+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if target, _, _ := p.last(); target != "" {
+		t.Fatalf("request to %s should bypass proxy", target)
+	}
+
+	proxy, err := buildProxy(&url.URL{Scheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"notexample.com", false},
+		{"127.0.0.5", true},
+		{"10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := proxy.bypass(tt.host); got != tt.want {
+			t.Errorf("bypass(%s) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rangeSpecRegexp 匹配 0-1023、500-、-500 这样的range，多个range以逗号分隔
+var rangeSpecRegexp = regexp.MustCompile(`^(\d+-\d*|-\d+)(,(\d+-\d*|-\d+))*$`)
+
+// resumeMeta 断点续传时用于If-Range校验的信息，保存在<output>.resume文件中
+type resumeMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func resumeMetaFile() string {
+	return curlFlag.OutputFile + ".resume"
+}
+
+func loadResumeMeta() *resumeMeta {
+	bs, err := os.ReadFile(resumeMetaFile())
+	if err != nil {
+		return nil
+	}
+	var meta resumeMeta
+	if err := json.Unmarshal(bs, &meta); err != nil {
+		log.Warnf("invalid resume meta file: %s", resumeMetaFile())
+		return nil
+	}
+	return &meta
+}
+
+// resumeURLKey 请求context中保存命令行指定的url，重定向后的请求继承context
+type resumeURLKey struct{}
+
+// withResumeURL 记录命令行指定的url，-C -比较的是该url而不是重定向后的url
+func withResumeURL(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), resumeURLKey{}, req.URL.String()))
+}
+
+func resumeURL(req *http.Request) string {
+	if u, ok := req.Context().Value(resumeURLKey{}).(string); ok {
+		return u
+	}
+	return req.URL.String()
+}
+
+// saveResumeMeta 下载开始时保存校验信息，下载中断后可通过-C -续传
+func saveResumeMeta(resp *http.Response) {
+	meta := resumeMeta{
+		URL:          resumeURL(resp.Request),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if meta.ETag == "" && meta.LastModified == "" {
+		return
+	}
+	bs, _ := json.Marshal(meta)
+	if err := os.WriteFile(resumeMetaFile(), bs, 0644); err != nil {
+		log.Warn("save resume meta error: ", err)
+	}
+}
+
+func removeResumeMeta() {
+	if err := os.Remove(resumeMetaFile()); err != nil && !os.IsNotExist(err) {
+		log.Warn("remove resume meta error: ", err)
+	}
+}
+
+// resumeOffset 解析-C，"-"表示从-o文件的当前大小继续
+func resumeOffset() (int64, error) {
+	switch curlFlag.ContinueAt {
+	case "":
+		return 0, nil
+	case "-":
+		if curlFlag.OutputFile == "" {
+			return 0, errors.New("-C - requires -o to get the resume offset")
+		}
+		stat, err := os.Stat(curlFlag.OutputFile)
+		if os.IsNotExist(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	default:
+		offset, err := strconv.ParseInt(curlFlag.ContinueAt, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("invalid continue-at offset: %s", curlFlag.ContinueAt)
+		}
+		return offset, nil
+	}
+}
+
+// setRangeHeader 根据-r或-C设置Range请求头
+func setRangeHeader(req *http.Request) error {
+	if curlFlag.Range != "" && curlFlag.ContinueAt != "" {
+		return errors.New("--range can not be used with --continue-at")
+	}
+	if curlFlag.Range != "" {
+		spec := strings.ReplaceAll(curlFlag.Range, " ", "")
+		if !rangeSpecRegexp.MatchString(spec) {
+			return fmt.Errorf("invalid range: %s", curlFlag.Range)
+		}
+		req.Header.Set("Range", "bytes="+spec)
+		log.Trace("add header: Range: bytes=" + spec)
+		return nil
+	}
+
+	offset, err := resumeOffset()
+	if err != nil || offset == 0 {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	log.Tracef("add header: Range: bytes=%d-", offset)
+
+	// 文件在服务端发生变化时，服务端会返回完整内容
+	if meta := loadResumeMeta(); meta != nil && meta.URL == req.URL.String() {
+		switch {
+		case meta.ETag != "" && !strings.HasPrefix(meta.ETag, "W/"):
+			req.Header.Set("If-Range", meta.ETag)
+		case meta.LastModified != "":
+			req.Header.Set("If-Range", meta.LastModified)
+		}
+		if v := req.Header.Get("If-Range"); v != "" {
+			log.Trace("add header: If-Range: " + v)
+		}
+	}
+	return nil
+}
+
+// parseContentRange 解析 Content-Range: bytes start-end/size，size未知时为-1
+func parseContentRange(s string) (start, end, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range: %s", s)
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	rng, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	if rng == "*" {
+		return -1, -1, size, nil
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	return start, end, size, nil
+}
+
+// isResumeComplete 续传时服务端返回416且文件大小与-C一致，说明文件已下载完成
+func isResumeComplete(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return false
+	}
+	offset, err := resumeOffset()
+	if err != nil || offset == 0 {
+		return false
+	}
+	_, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	return err == nil && size == offset
+}
+
+// openOutputFile 打开-o文件，续传且服务端返回206时追加写入，否则覆盖写入
+func openOutputFile(resp *http.Response) (*os.File, error) {
+	offset, err := resumeOffset()
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 || resp.StatusCode != http.StatusPartialContent {
+		if offset > 0 {
+			log.Warn("server does not support resume or file changed, download from beginning")
+		}
+		f, err := os.Create(curlFlag.OutputFile)
+		if err == nil {
+			saveResumeMeta(resp)
+		}
+		return f, err
+	}
+
+	start, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if start != offset {
+		return nil, fmt.Errorf("server resumed at offset %d, expected %d", start, offset)
+	}
+	f, err := os.OpenFile(curlFlag.OutputFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Debugf("resume download at offset: %d", offset)
+	return f, nil
+}
+
+func isMultipartByteRanges(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/byteranges"
+}
+
+// outputByteRanges 输出multipart/byteranges响应，写入文件时每段写入其对应的偏移，否则按顺序输出到标准输出
+func outputByteRanges(resp *http.Response) error {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+
+	var f *os.File
+	if curlFlag.OutputFile != "" {
+		if f, err = os.Create(curlFlag.OutputFile); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		contentRange := part.Header.Get("Content-Range")
+		log.Debugf("byte range part: %s", contentRange)
+		if f == nil {
+			if _, err := io.Copy(os.Stdout, part); err != nil {
+				return err
+			}
+			continue
+		}
+		start, _, _, err := parseContentRange(contentRange)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.NewOffsetWriter(f, start), part); err != nil {
+			return err
+		}
+	}
+	if f != nil {
+		log.Println("save file success: " + curlFlag.OutputFile)
+	}
+	return nil
+}
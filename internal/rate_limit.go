@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimiters 上传与下载的限速器，未限速时为nil；分段下载的多个连接共享同一个下载限速器
+type rateLimiters struct {
+	upload   *tokenBucket
+	download *tokenBucket
+}
+
+// parseRate 解析 100K、1.5M、1G 这样的速率，单位为字节每秒，后缀按1024进制
+func parseRate(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate: %s", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// buildRateLimiters 按--limit-rate、--limit-rate-upload、--limit-rate-download创建限速器
+func buildRateLimiters() (*rateLimiters, error) {
+	build := func(rate string) (*tokenBucket, error) {
+		if rate == "" {
+			rate = curlFlag.LimitRate
+		}
+		if rate == "" {
+			return nil, nil
+		}
+		n, err := parseRate(rate)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		return newTokenBucket(n), nil
+	}
+	var (
+		l   rateLimiters
+		err error
+	)
+	if l.upload, err = build(curlFlag.LimitRateUpload); err != nil {
+		return nil, err
+	}
+	if l.download, err = build(curlFlag.LimitRateDownload); err != nil {
+		return nil, err
+	}
+	if l.upload != nil {
+		log.Debugf("limit upload rate: %d bytes/s", int64(l.upload.rate))
+	}
+	if l.download != nil {
+		log.Debugf("limit download rate: %d bytes/s", int64(l.download.rate))
+	}
+	return &l, nil
+}
+
+// tokenBucket 令牌桶，每秒产生rate个令牌，一个令牌对应一个字节
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	// 桶容量为1/8秒的流量，避免开始时突发过大
+	burst := float64(rate) / 8
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait 取出n个令牌，令牌不足时等待
+func (b *tokenBucket) wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	// 令牌可以透支，透支的部分通过等待补齐
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(d)
+}
+
+// chunkSize 单次读取的最大字节数，不超过桶容量
+func (b *tokenBucket) chunkSize(n int) int {
+	if burst := int(b.burst); n > burst {
+		return burst
+	}
+	return n
+}
+
+type rateLimitedReader struct {
+	io.ReadCloser
+	bucket *tokenBucket
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.ReadCloser.Read(p)
+	}
+	n, err := r.ReadCloser.Read(p[:r.bucket.chunkSize(len(p))])
+	r.bucket.wait(n)
+	return n, err
+}
+
+// newRateLimitedReader bucket为nil时不限速
+func newRateLimitedReader(body io.ReadCloser, bucket *tokenBucket) io.ReadCloser {
+	if bucket == nil || body == nil || body == http.NoBody {
+		return body
+	}
+	return &rateLimitedReader{ReadCloser: body, bucket: bucket}
+}
+
+// limitRequestBody 对请求body限速，重试与重定向时重新生成的body同样限速
+func (l *rateLimiters) limitRequestBody(req *http.Request) {
+	if l == nil || l.upload == nil {
+		return
+	}
+	req.Body = newRateLimitedReader(req.Body, l.upload)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newRateLimitedReader(body, l.upload), nil
+		}
+	}
+}
+
+// limitResponseBody 对响应body限速
+func (l *rateLimiters) limitResponseBody(body io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return body
+	}
+	return newRateLimitedReader(body, l.download)
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// headerArg 一个-H参数，remove表示 "Name:" 删除默认填充的header
+type headerArg struct {
+	name   string
+	value  string
+	remove bool
+}
+
+// rawHeadersKey 请求context中保存-H参数的key，--raw-headers时按其中的顺序与大小写写出
+type rawHeadersKey struct{}
+
+// withRawHeaders 将-H参数保存到请求的context中，重定向的请求会继承context
+func withRawHeaders(req *http.Request, headers []headerArg) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), rawHeadersKey{}, headers))
+}
+
+// requestRawHeaders 按-H参数的顺序与大小写，从请求自身的header中取值。
+// 重定向时被移除的header(如跨主机的Authorization、Cookie)不会再写出
+func requestRawHeaders(req *http.Request) []headerArg {
+	args, _ := req.Context().Value(rawHeadersKey{}).([]headerArg)
+	used := map[string]int{}
+	var headers []headerArg
+	for _, h := range args {
+		if h.remove {
+			headers = append(headers, h)
+			continue
+		}
+		key := http.CanonicalHeaderKey(h.name)
+		values := req.Header[key]
+		if used[key] >= len(values) {
+			continue
+		}
+		headers = append(headers, headerArg{name: h.name, value: values[used[key]]})
+		used[key]++
+	}
+	return headers
+}
+
+// rawHeaderTransport 为每个请求计算需要原样写出的header，通过context传递给拨号时创建的rawHeaderConn
+type rawHeaderTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *rawHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req.WithContext(context.WithValue(req.Context(), rawHeadersKey{}, requestRawHeaders(req))))
+}
+
+// parseHeaderArgs 解析-H参数，与curl一致:
+// "Name: value" 设置header，"Name:" 删除默认header，"Name;" 发送空值的header
+func parseHeaderArgs(args []string) ([]headerArg, error) {
+	var headers []headerArg
+	for _, h := range args {
+		colon := strings.IndexByte(h, ':')
+		semicolon := strings.IndexByte(h, ';')
+		switch {
+		case colon > 0 && (semicolon == -1 || colon < semicolon):
+			name, value := strings.TrimSpace(h[:colon]), strings.TrimSpace(h[colon+1:])
+			headers = append(headers, headerArg{name: name, value: value, remove: value == ""})
+		case semicolon > 0 && strings.TrimSpace(h[semicolon+1:]) == "":
+			headers = append(headers, headerArg{name: strings.TrimSpace(h[:semicolon])})
+		default:
+			return nil, fmt.Errorf("invalid header: %s", h)
+		}
+	}
+	return headers, nil
+}
+
+// applyHeaderArgs 将-H参数设置到请求中，--raw-headers时同名header追加而不是覆盖
+func applyHeaderArgs(req *http.Request, headers []headerArg) {
+	added := map[string]bool{}
+	for _, h := range headers {
+		key := http.CanonicalHeaderKey(h.name)
+		switch {
+		case h.remove && key == "User-Agent":
+			// http.Transport 在没有User-Agent时会填充默认值，空值表示不发送
+			req.Header["User-Agent"] = []string{""}
+			log.Trace("remove header: User-Agent")
+		case h.remove:
+			req.Header.Del(key)
+			log.Tracef("remove header: %s", h.name)
+		case curlFlag.RawHeaders && added[key]:
+			req.Header.Add(key, h.value)
+			log.Tracef("add header: %s: %s", h.name, h.value)
+		default:
+			req.Header.Set(key, h.value)
+			added[key] = true
+			log.Tracef("add header: %s: %s", h.name, h.value)
+		}
+	}
+}
+
+// rawHeaderConn 将http.Transport写出的header替换为-H指定的header，保留大小写、顺序与重复项。
+// 只改写连接上的第一个请求，因此需要禁用keep-alive
+type rawHeaderConn struct {
+	net.Conn
+	headers []headerArg
+	buf     []byte
+	done    bool
+}
+
+func (c *rawHeaderConn) Write(b []byte) (int, error) {
+	if c.done {
+		return c.Conn.Write(b)
+	}
+	c.buf = append(c.buf, b...)
+	end := bytes.Index(c.buf, []byte("\r\n\r\n"))
+	if end < 0 {
+		return len(b), nil
+	}
+	c.done = true
+	head := rewriteHeaders(c.buf[:end], c.headers)
+	log.Debugf("raw headers: \n%s", head)
+	out := append(head, c.buf[end:]...)
+	c.buf = nil
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// rewriteHeaders 保留请求行与未被-H指定的header，-H指定的header按原样追加在最后
+func rewriteHeaders(head []byte, headers []headerArg) []byte {
+	specified := map[string]bool{}
+	for _, h := range headers {
+		specified[strings.ToLower(h.name)] = true
+	}
+	lines := bytes.Split(head, []byte("\r\n"))
+	out := append([]byte(nil), lines[0]...)
+	for _, line := range lines[1:] {
+		name, _, _ := bytes.Cut(line, []byte(":"))
+		if specified[strings.ToLower(string(bytes.TrimSpace(name)))] {
+			continue
+		}
+		out = append(out, "\r\n"...)
+		out = append(out, line...)
+	}
+	for _, h := range headers {
+		if h.remove {
+			continue
+		}
+		out = append(out, "\r\n"+h.name+":"...)
+		if h.value != "" {
+			out = append(out, " "+h.value...)
+		}
+	}
+	return out
+}
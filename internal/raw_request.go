@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rawRequestTarget 解析--raw-request的目标，支持 host:port、http://host[:port] 与 https://host[:port]
+func rawRequestTarget(target string) (*url.URL, error) {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw request target: %s (%s)", target, err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("raw request only supports http and https, got %s", u.Scheme)
+	}
+	u.Host = canonicalAddr(u)
+	return u, nil
+}
+
+// readRawRequest 读取--raw-request指定的文件，-表示从stdin读取
+func readRawRequest() ([]byte, error) {
+	if curlFlag.RawRequest == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(curlFlag.RawRequest)
+}
+
+// sendRawRequest 将请求原样写入TCP或TLS连接，响应能解析时按普通响应输出，否则原样输出
+func sendRawRequest(target string) error {
+	u, err := rawRequestTarget(target)
+	if err != nil {
+		return err
+	}
+	raw, err := readRawRequest()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := buildTLSConfig()
+	if err != nil {
+		return err
+	}
+	proxy, err := buildProxy(u)
+	if err != nil {
+		return err
+	}
+	resolver, err := buildHostResolver()
+	if err != nil {
+		return err
+	}
+	dial, err := buildDialContext(resolver, proxy)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if curlFlag.MaxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(curlFlag.MaxTime*float64(time.Second)))
+		defer cancel()
+	}
+	conn, err := dial(ctx, "tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "https" {
+		tlsConn, err := handshakeTLS(ctx, conn, tlsConfig, u.Host)
+		if err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	log.Debugf("send raw request to %s, %d bytes", u.Host, len(raw))
+	log.Tracef("raw request: \n%q", raw)
+	if _, err := conn.Write(raw); err != nil {
+		return err
+	}
+
+	// 记录解析响应头时读取的数据，解析失败时原样输出
+	received := &rawRecorder{}
+	br := bufio.NewReader(io.TeeReader(conn, received))
+	resp, err := readRawResponse(br, rawRequestMethod(raw))
+	if err != nil {
+		log.Warnf("parse response error, dump raw response: %v", err)
+		if _, err := os.Stdout.Write(received.buf.Bytes()); err != nil {
+			return err
+		}
+		_, err = io.Copy(os.Stdout, conn)
+		return err
+	}
+	// 解析成功后body流式输出，不再记录
+	received.stop()
+	defer resp.Body.Close()
+
+	if err := outputResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		fmt.Println()
+		err = &statusError{code: resp.StatusCode}
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// readRawResponse 读取响应，跳过1xx的中间响应，HEAD请求的响应没有body
+func readRawResponse(br *bufio.Reader, method string) (*http.Response, error) {
+	req := &http.Request{Method: method}
+	for {
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+		log.Debugf("skip interim response: %s", resp.Status)
+	}
+}
+
+// rawRequestMethod 返回请求行中的方法，无法识别时按GET处理
+func rawRequestMethod(raw []byte) string {
+	line, _, _ := bytes.Cut(raw, []byte("\n"))
+	method, _, ok := bytes.Cut(line, []byte(" "))
+	if !ok || len(method) == 0 {
+		return http.MethodGet
+	}
+	return string(method)
+}
+
+// rawRecorder 记录读取的数据，stop之后丢弃
+type rawRecorder struct {
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (r *rawRecorder) Write(p []byte) (int, error) {
+	if r.stopped {
+		return len(p), nil
+	}
+	return r.buf.Write(p)
+}
+
+func (r *rawRecorder) stop() {
+	r.stopped = true
+	r.buf = bytes.Buffer{}
+}
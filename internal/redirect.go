@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectMethod 按curl语义返回重定向后的method，以及是否需要保留body
+func redirectMethod(method string, code int) (string, bool) {
+	switch code {
+	case http.StatusMovedPermanently:
+		if method == http.MethodPost && !curlFlag.Post301 {
+			return http.MethodGet, false
+		}
+	case http.StatusFound:
+		if method == http.MethodPost && !curlFlag.Post302 {
+			return http.MethodGet, false
+		}
+	case http.StatusSeeOther:
+		if method != http.MethodHead && !(method == http.MethodPost && curlFlag.Post303) {
+			return http.MethodGet, false
+		}
+	}
+	return method, true
+}
+
+func buildRedirectRequest(req *http.Request, resp *http.Response) (*http.Request, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect location: %s (%s)", resp.Header.Get("Location"), err.Error())
+	}
+
+	method, includeBody := redirectMethod(req.Method, resp.StatusCode)
+	next, err := http.NewRequestWithContext(req.Context(), method, loc.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	next.Header = req.Header.Clone()
+
+	if includeBody && req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("can not resend request body for redirect to: %s", loc)
+		}
+		if next.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+		next.GetBody = req.GetBody
+		next.ContentLength = req.ContentLength
+		next.Trailer = req.Trailer.Clone()
+	} else {
+		// 丢弃body时同时移除body相关的header
+		for _, k := range []string{"Content-Type", "Content-Length", "Content-MD5", "Transfer-Encoding", "Trailer"} {
+			next.Header.Del(k)
+		}
+	}
+
+	// 不向其他主机泄露认证信息
+	if next.URL.Host != req.URL.Host {
+		for _, k := range []string{"Authorization", "Cookie"} {
+			if next.Header.Get(k) != "" {
+				log.Tracef("remove header %s for redirect to other host: %s", k, next.URL.Host)
+				next.Header.Del(k)
+			}
+		}
+	}
+	return next, nil
+}
+
+// doRequest 发送请求，-L时按curl语义跟随重定向，每一跳都会输出请求与响应
+func doRequest(c *http.Client, req *http.Request) (*http.Response, error) {
+	for redirects := 0; ; redirects++ {
+		// output request
+		if err := outputRequest(req); err != nil {
+			return nil, err
+		}
+
+		// c.Do会把cookie jar中的cookie写入req.Header，下一跳需基于原始header构建
+		header := req.Header.Clone()
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curlFlag.Location || !isRedirectStatus(resp.StatusCode) || resp.Header.Get("Location") == "" {
+			return resp, nil
+		}
+		if curlFlag.MaxRedirs >= 0 && redirects >= curlFlag.MaxRedirs {
+			resp.Body.Close()
+			return nil, fmt.Errorf("maximum (%d) redirects followed", curlFlag.MaxRedirs)
+		}
+
+		// 输出中间跳的响应
+		if curlFlag.Head {
+			bs, err := httputil.DumpResponse(resp, false)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			fmt.Print(string(bs))
+		} else if err := logResponse(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		req.Header = header
+		next, err := buildRedirectRequest(req, resp)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("follow redirect (%d): %s %s", resp.StatusCode, next.Method, next.URL)
+		req = next
+	}
+}
@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cutHostField 从s中切出一个host字段，支持[ipv6]形式，返回字段与剩余部分
+func cutHostField(s string) (field, rest string, ok bool) {
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return "", "", false
+		}
+		field, rest = s[1:end], s[end+1:]
+		if rest == "" {
+			return field, "", true
+		}
+		if rest[0] != ':' {
+			return "", "", false
+		}
+		return field, rest[1:], true
+	}
+	field, rest, _ = strings.Cut(s, ":")
+	return field, rest, true
+}
+
+// resolveOverrides --resolve指定的地址，key为小写的host:port，host为*时匹配任意host
+type resolveOverrides map[string][]net.IP
+
+// parseResolve 解析 --resolve <host:port:addr[,addr]...>
+func parseResolve(entries []string) (resolveOverrides, error) {
+	overrides := resolveOverrides{}
+	for _, entry := range entries {
+		invalid := fmt.Errorf("invalid resolve: %s, format: host:port:addr[,addr]", entry)
+		host, rest, ok := cutHostField(entry)
+		if !ok || host == "" {
+			return nil, invalid
+		}
+		port, addrs, ok := strings.Cut(rest, ":")
+		if !ok || port == "" || addrs == "" {
+			return nil, invalid
+		}
+		var ips []net.IP
+		for _, addr := range strings.Split(addrs, ",") {
+			ip := net.ParseIP(strings.Trim(strings.TrimSpace(addr), "[]"))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address in resolve: %s", addr)
+			}
+			ips = append(ips, ip)
+		}
+		key := strings.ToLower(net.JoinHostPort(host, port))
+		overrides[key] = append(overrides[key], ips...)
+	}
+	return overrides, nil
+}
+
+func (o resolveOverrides) lookup(host, port string) ([]net.IP, bool) {
+	if ips, ok := o[strings.ToLower(net.JoinHostPort(host, port))]; ok {
+		return ips, true
+	}
+	ips, ok := o[net.JoinHostPort("*", port)]
+	return ips, ok
+}
+
+// connectTo --connect-to HOST1:PORT1:HOST2:PORT2，为空的字段匹配任意值或保持不变
+type connectTo struct {
+	host1, port1 string
+	host2, port2 string
+}
+
+func parseConnectTo(entries []string) ([]connectTo, error) {
+	var result []connectTo
+	for _, entry := range entries {
+		invalid := fmt.Errorf("invalid connect-to: %s, format: HOST1:PORT1:HOST2:PORT2", entry)
+		var (
+			ct   connectTo
+			rest string
+			ok   bool
+		)
+		if ct.host1, rest, ok = cutHostField(entry); !ok {
+			return nil, invalid
+		}
+		if ct.port1, rest, ok = strings.Cut(rest, ":"); !ok {
+			return nil, invalid
+		}
+		if ct.host2, rest, ok = cutHostField(rest); !ok {
+			return nil, invalid
+		}
+		ct.port2 = rest
+		result = append(result, ct)
+	}
+	return result, nil
+}
+
+// applyConnectTo 返回实际连接的host与port，第一个匹配的规则生效
+func applyConnectTo(rules []connectTo, host, port string) (string, string) {
+	for _, rule := range rules {
+		if rule.host1 != "" && !strings.EqualFold(rule.host1, host) {
+			continue
+		}
+		if rule.port1 != "" && rule.port1 != port {
+			continue
+		}
+		if rule.host2 != "" {
+			host = rule.host2
+		}
+		if rule.port2 != "" {
+			port = rule.port2
+		}
+		return host, port
+	}
+	return host, port
+}
+
+// buildResolver 按--doh-url或--dns-servers创建解析器，都未指定时使用系统解析器
+func buildResolver() (*net.Resolver, error) {
+	switch {
+	case curlFlag.DoHURL != "":
+		u, err := url.Parse(curlFlag.DoHURL)
+		if err != nil || u.Scheme != "https" && u.Scheme != "http" {
+			return nil, fmt.Errorf("invalid doh url: %s", curlFlag.DoHURL)
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, client: client, url: u.String()}, nil
+			},
+		}, nil
+	case len(curlFlag.DNSServers) > 0:
+		var servers []string
+		for _, s := range curlFlag.DNSServers {
+			host, port, err := net.SplitHostPort(s)
+			if err != nil {
+				host, port = strings.Trim(s, "[]"), "53"
+			}
+			if net.ParseIP(host) == nil {
+				return nil, fmt.Errorf("invalid dns server: %s", s)
+			}
+			servers = append(servers, net.JoinHostPort(host, port))
+		}
+		// 每次查询轮流使用下一个服务器，查询失败重试时即切换到其他服务器
+		var next atomic.Uint32
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				server := servers[int(next.Add(1)-1)%len(servers)]
+				log.Tracef("dns query %s via %s", network, server)
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}, nil
+	}
+	return net.DefaultResolver, nil
+}
+
+// dohConn 将Go解析器发出的DNS over TCP报文转换为RFC 8484的DoH请求。
+// 不实现net.PacketConn，Go解析器会使用带2字节长度前缀的TCP报文格式
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+	wbuf     bytes.Buffer
+	rbuf     bytes.Buffer
+}
+
+func (c *dohConn) exchange(msg []byte) ([]byte, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh server response status code: %d", resp.StatusCode)
+	}
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 65535+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) > 65535 {
+		return nil, errors.New("doh response too large")
+	}
+	log.Tracef("doh query %d bytes, response %d bytes", len(msg), len(bs))
+	return bs, nil
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.wbuf.Write(b)
+	for c.wbuf.Len() >= 2 {
+		bs := c.wbuf.Bytes()
+		n := int(binary.BigEndian.Uint16(bs))
+		if len(bs) < 2+n {
+			break
+		}
+		msg := append([]byte(nil), bs[2:2+n]...)
+		c.wbuf.Next(2 + n)
+		resp, err := c.exchange(msg)
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+		c.rbuf.Write(resp)
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "doh" }
+func (a dohAddr) String() string  { return string(a) }
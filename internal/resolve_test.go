@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub 本地DNS服务，按records应答A与AAAA查询，其他域名返回NXDOMAIN
+type dnsStub struct {
+	records map[string][]net.IP
+	queries atomic.Int32
+}
+
+// answer 根据查询报文构造应答报文
+func (s *dnsStub) answer(query []byte) ([]byte, error) {
+	s.queries.Add(1)
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	header.Response, header.RecursionAvailable = true, true
+	ips, ok := s.records[q.Name.String()]
+	if !ok {
+		header.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, ip := range ips {
+		switch {
+		case q.Type == dnsmessage.TypeA && ip.To4() != nil:
+			var a dnsmessage.AResource
+			copy(a.A[:], ip.To4())
+			err = b.AResource(rh, a)
+		case q.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], ip.To16())
+			err = b.AAAAResource(rh, aaaa)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// serveUDP 在本地UDP端口提供DNS服务，返回服务地址
+func (s *dnsStub) serveUDP(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp, err := s.answer(buf[:n]); err == nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// dohHandler RFC 8484 的POST请求
+func (s *dnsStub) dohHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query, _ := io.ReadAll(r.Body)
+	resp, err := s.answer(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(resp)
+}
+
+func newDNSStub() *dnsStub {
+	return &dnsStub{records: map[string][]net.IP{
+		"canary.test.": {net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}}
+}
+
+func TestParseResolve(t *testing.T) {
+	overrides, err := parseResolve([]string{"Example.com:443:127.0.0.1,[::1]", "*:80:10.0.0.1", "[::1]:8080:127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host, port string
+		want       []net.IP
+	}{
+		{"example.com", "443", []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+		{"any.host", "80", []net.IP{net.ParseIP("10.0.0.1")}},
+		{"::1", "8080", []net.IP{net.ParseIP("127.0.0.2")}},
+		{"example.com", "8443", nil},
+	}
+	for _, tt := range tests {
+		ips, _ := overrides.lookup(tt.host, tt.port)
+		if !reflect.DeepEqual(ips, tt.want) {
+			t.Errorf("lookup(%s, %s) = %v, want %v", tt.host, tt.port, ips, tt.want)
+		}
+	}
+
+	for _, entry := range []string{"example.com:443", "example.com::127.0.0.1", "example.com:443:not-ip"} {
+		if _, err := parseResolve([]string{entry}); err == nil {
+			t.Errorf("parseResolve(%q) expect error", entry)
+		}
+	}
+}
+
+func TestConnectTo(t *testing.T) {
+	rules, err := parseConnectTo([]string{"example.com:443:backend:8443", ":80:[::1]:", "::other:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host, port         string
+		wantHost, wantPort string
+	}{
+		{"example.com", "443", "backend", "8443"},
+		{"EXAMPLE.com", "443", "backend", "8443"},
+		{"example.com", "80", "::1", "80"},
+		{"foo.com", "8080", "other", "8080"},
+	}
+	for _, tt := range tests {
+		host, port := applyConnectTo(rules, tt.host, tt.port)
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("applyConnectTo(%s, %s) = %s:%s, want %s:%s", tt.host, tt.port, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+	if _, err := parseConnectTo([]string{"example.com"}); err == nil {
+		t.Error("expect error for invalid connect-to")
+	}
+}
+
+func TestLookupAddressFamily(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags *Flags
+		want  []net.IP
+	}{
+		{"ipv4 first", &Flags{}, []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+		{"ipv4 only", &Flags{IPv4: true}, []net.IP{net.ParseIP("127.0.0.1")}},
+		{"ipv6 only", &Flags{IPv6: true}, []net.IP{net.ParseIP("::1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags.Resolve = []string{"dual.test:80:::1,127.0.0.1"}
+			setFlags(t, tt.flags)
+			r, err := buildHostResolver()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ips, err := r.lookup(context.Background(), "dual.test", "80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ips, tt.want) {
+				t.Fatalf("lookup = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomDNSResolver(t *testing.T) {
+	stub := newDNSStub()
+	udpAddr := stub.serveUDP(t)
+	doh := httptest.NewServer(http.HandlerFunc(stub.dohHandler))
+	defer doh.Close()
+
+	tests := []struct {
+		name  string
+		flags *Flags
+	}{
+		{"dns servers", &Flags{DNSServers: []string{udpAddr}}},
+		{"doh url", &Flags{DoHURL: doh.URL + "/dns-query"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags.IPv4 = true
+			setFlags(t, tt.flags)
+			before := stub.queries.Load()
+			r, err := buildHostResolver()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ips, err := r.lookup(context.Background(), "canary.test", "80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ips) != 1 || !ips[0].Equal(net.ParseIP("127.0.0.1")) {
+				t.Fatalf("lookup = %v, want [127.0.0.1]", ips)
+			}
+			if stub.queries.Load() == before {
+				t.Fatal("dns stub is not queried")
+			}
+			if _, err := r.lookup(context.Background(), "missing.test", "80"); err == nil {
+				t.Fatal("expect error for NXDOMAIN")
+			}
+		})
+	}
+}
+
+// TestDialOverrides --resolve与--connect-to只改变连接的地址，Host保持url中的值
+func TestDialOverrides(t *testing.T) {
+	stub := newDNSStub()
+	udpAddr := stub.serveUDP(t)
+	var gotHost atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost.Store(r.Host)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port := u.Port()
+
+	tests := []struct {
+		name  string
+		flags *Flags
+		url   string
+	}{
+		{"resolve", &Flags{Resolve: []string{"backend.test:" + port + ":127.0.0.1"}}, "http://backend.test:" + port + "/"},
+		{"resolve wildcard", &Flags{Resolve: []string{"*:" + port + ":127.0.0.1"}}, "http://any.test:" + port + "/"},
+		{"connect to", &Flags{ConnectTo: []string{"www.example.test:80:127.0.0.1:" + port}}, "http://www.example.test/"},
+		{"connect to with dns servers", &Flags{ConnectTo: []string{"www.example.test:80:canary.test:" + port}, DNSServers: []string{udpAddr}, IPv4: true}, "http://www.example.test/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.flags)
+			r, err := buildHostResolver()
+			if err != nil {
+				t.Fatal(err)
+			}
+			dial, err := buildDialContext(r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport := &http.Transport{DialContext: dial}
+			defer transport.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: transport}).Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			want, _ := url.Parse(tt.url)
+			if got := gotHost.Load(); got != want.Host {
+				t.Fatalf("Host = %v, want %s", got, want.Host)
+			}
+		})
+	}
+}
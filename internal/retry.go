@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retryInitialDelay = time.Second
+	retryMaxDelay     = 10 * time.Minute
+)
+
+// isTransientStatus 与curl一致的可重试状态码
+func isTransientStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// shouldRetry 判断本次请求结果是否需要重试，返回重试原因
+func shouldRetry(resp *http.Response, err error) (bool, string) {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case curlFlag.RetryAllErrors:
+			return true, err.Error()
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return true, "timeout"
+		case curlFlag.RetryConnRefused && errors.Is(err, syscall.ECONNREFUSED):
+			return true, "connection refused"
+		}
+		return false, ""
+	}
+	switch {
+	case isTransientStatus(resp.StatusCode):
+		return true, fmt.Sprintf("HTTP error %d", resp.StatusCode)
+	case curlFlag.RetryAllErrors && resp.StatusCode >= 400:
+		return true, fmt.Sprintf("HTTP error %d", resp.StatusCode)
+	}
+	return false, ""
+}
+
+// parseRetryAfter 解析Retry-After，支持秒数与HTTP-date两种格式
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	log.Warnf("invalid Retry-After header: %s", v)
+	return 0, false
+}
+
+// retryDelay 计算第attempt次重试前的等待时间，未指定--retry-delay时使用带抖动的指数退避
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := parseRetryAfter(resp); ok {
+			return d
+		}
+	}
+	if curlFlag.RetryDelay > 0 {
+		return time.Duration(curlFlag.RetryDelay * float64(time.Second))
+	}
+	d := retryInitialDelay << attempt
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	// 在[d/2, d)之间随机抖动
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// newRetryRequest 基于原始请求构建新一次尝试的请求，body需要重新生成
+func newRetryRequest(req *http.Request, header http.Header) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	next.Header = header.Clone()
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("can not resend request body for retry")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// doRequestWithRetry 按--retry等参数发送请求，失败时重试
+func doRequestWithRetry(c *http.Client, req *http.Request, info *TransferInfo) (*http.Response, error) {
+	start := time.Now()
+	// c.Do会修改req.Header，每次重试基于原始header构建
+	header := req.Header.Clone()
+	for attempt := 0; ; attempt++ {
+		resp, err := doRequest(c, req)
+		retry, reason := shouldRetry(resp, err)
+		if !retry || attempt >= curlFlag.Retry {
+			return resp, err
+		}
+
+		delay := retryDelay(attempt, resp)
+		if curlFlag.RetryMaxTime > 0 && time.Since(start)+delay > time.Duration(curlFlag.RetryMaxTime*float64(time.Second)) {
+			log.Warnf("retry max time %.3fs reached, give up", curlFlag.RetryMaxTime)
+			return resp, err
+		}
+
+		next, nerr := newRetryRequest(req, header)
+		if nerr != nil {
+			log.Warn("can not retry: ", nerr)
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Warnf("Problem: %s. Will retry in %s. %d retries left.", reason, delay.Round(time.Millisecond), curlFlag.Retry-attempt)
+		time.Sleep(delay)
+		info.onRetry()
+		req = next
+	}
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// 每个分段至少重试的次数
+	segmentMinRetries = 3
+	// 小于该大小的文件不分段
+	segmentMinSize = 1024 * 1024
+)
+
+var errSegmentFileChanged = errors.New("file changed during download")
+
+// segmentProbe 分段下载前探测到的文件信息
+type segmentProbe struct {
+	url        string
+	size       int64
+	etag       string
+	contentMD5 string
+}
+
+// probeSegments 先发送HEAD请求探测文件大小，HEAD不可用时使用1字节的range请求；
+// 服务端不支持range时返回的响应可直接作为普通下载的响应
+func probeSegments(c *http.Client, req *http.Request) (*segmentProbe, *http.Response, error) {
+	head := req.Clone(req.Context())
+	head.Method = http.MethodHead
+	resp, err := doRequest(c, head)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && resp.ContentLength > 0 && resp.Header.Get("Accept-Ranges") == "bytes" {
+			return &segmentProbe{
+				url:        resp.Request.URL.String(),
+				size:       resp.ContentLength,
+				etag:       resp.Header.Get("ETag"),
+				contentMD5: resp.Header.Get("Content-MD5"),
+			}, nil, nil
+		}
+		log.Debugf("segments: HEAD probe got %d, try range probe", resp.StatusCode)
+	}
+
+	probe := req.Clone(req.Context())
+	probe.Header.Set("Range", "bytes=0-0")
+	resp, err = doRequest(c, probe)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// 不支持range，直接使用该响应
+		return nil, resp, nil
+	}
+	defer resp.Body.Close()
+	_, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if size < 0 {
+		return nil, nil, errors.New("segments: server does not report file size")
+	}
+	return &segmentProbe{
+		url:  resp.Request.URL.String(),
+		size: size,
+		etag: resp.Header.Get("ETag"),
+	}, nil, nil
+}
+
+type segment struct {
+	index      int
+	start, end int64
+	// 已写入的字节数，重试时从这里继续
+	written int64
+}
+
+func downloadSegment(c *http.Client, req *http.Request, probe *segmentProbe, f *os.File, seg *segment, progress *Progress, limiters *rateLimiters) error {
+	segReq, err := http.NewRequestWithContext(req.Context(), req.Method, probe.url, nil)
+	if err != nil {
+		return err
+	}
+	segReq.Header = req.Header.Clone()
+	segReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.start+seg.written, seg.end))
+	if probe.etag != "" && !strings.HasPrefix(probe.etag, "W/") {
+		segReq.Header.Set("If-Range", probe.etag)
+	}
+
+	resp, err := c.Do(segReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if etag := resp.Header.Get("ETag"); probe.etag != "" && etag != "" && etag != probe.etag {
+		return fmt.Errorf("%w, etag: %s, expected: %s", errSegmentFileChanged, etag, probe.etag)
+	}
+	start, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if start != seg.start+seg.written {
+		return fmt.Errorf("server returned range start %d, expected %d", start, seg.start+seg.written)
+	}
+
+	body := progress.WrapDownload(limiters.limitResponseBody(resp.Body), probe.size)
+	w := io.NewOffsetWriter(f, seg.start+seg.written)
+	n, err := io.Copy(w, io.LimitReader(body, seg.end-seg.start+1-seg.written))
+	seg.written += n
+	if err != nil {
+		return err
+	}
+	if seg.written != seg.end-seg.start+1 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// downloadSegmentWithRetry 每个分段独立重试，已下载的部分不会重复下载
+func downloadSegmentWithRetry(c *http.Client, req *http.Request, probe *segmentProbe, f *os.File, seg *segment, progress *Progress, limiters *rateLimiters) error {
+	retries := curlFlag.Retry
+	if retries < segmentMinRetries {
+		retries = segmentMinRetries
+	}
+	for attempt := 0; ; attempt++ {
+		err := downloadSegment(c, req, probe, f, seg, progress, limiters)
+		if err == nil {
+			log.Debugf("segment %d [%d-%d] done", seg.index, seg.start, seg.end)
+			return nil
+		}
+		if attempt >= retries || errors.Is(err, errSegmentFileChanged) {
+			return fmt.Errorf("segment %d [%d-%d] error: %s", seg.index, seg.start, seg.end, err.Error())
+		}
+		delay := retryDelay(attempt, nil)
+		log.Warnf("segment %d [%d-%d] error: %s. Will retry in %s.", seg.index, seg.start, seg.end, err.Error(), delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+// verifySegments 校验下载完成的文件大小、Content-MD5或MD5形式的ETag
+func verifySegments(probe *segmentProbe) error {
+	stat, err := os.Stat(curlFlag.OutputFile)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != probe.size {
+		return fmt.Errorf("size mismatch: %d, expected: %d", stat.Size(), probe.size)
+	}
+
+	etagMD5 := md5ETag(probe.etag)
+	if probe.contentMD5 == "" && etagMD5 == nil {
+		return nil
+	}
+	f, err := os.Open(curlFlag.OutputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	sum := h.Sum(nil)
+
+	if probe.contentMD5 != "" {
+		if md5 := base64Digest(sum); md5 != probe.contentMD5 {
+			return fmt.Errorf("Content-MD5 mismatch: %s, expected: %s", md5, probe.contentMD5)
+		}
+		log.Debug("segments: Content-MD5 verified")
+		return nil
+	}
+	if !bytes.Equal(sum, etagMD5) {
+		return fmt.Errorf("ETag mismatch: %s, expected: %s", hex.EncodeToString(sum), hex.EncodeToString(etagMD5))
+	}
+	log.Debug("segments: ETag verified")
+	return nil
+}
+
+// downloadSegments 使用--segments个并发range请求下载到-o文件。
+// 服务端不支持range或文件太小时返回的响应需按普通下载处理，分段下载完成时返回nil
+func downloadSegments(c *http.Client, req *http.Request, progress *Progress, limiters *rateLimiters) (*http.Response, error) {
+	if curlFlag.OutputFile == "" {
+		return nil, errors.New("--segments requires -o")
+	}
+	if req.Method != http.MethodGet {
+		return nil, errors.New("--segments only supports GET request")
+	}
+
+	probe, resp, err := probeSegments(c, req)
+	if err != nil || resp != nil {
+		if resp != nil {
+			log.Warn("server does not support range requests, download without segments")
+		}
+		return resp, err
+	}
+
+	n := int64(curlFlag.Segments)
+	if probe.size < segmentMinSize || probe.size < n {
+		n = 1
+	}
+	log.Debugf("segments: download %d bytes with %d segments, etag: %s", probe.size, n, probe.etag)
+
+	f, err := os.Create(curlFlag.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// 预分配文件
+	if err := f.Truncate(probe.size); err != nil {
+		return nil, err
+	}
+
+	segSize := (probe.size + n - 1) / n
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := int64(0); i < n; i++ {
+		seg := &segment{index: int(i), start: i * segSize, end: (i+1)*segSize - 1}
+		if seg.start >= probe.size {
+			break
+		}
+		if seg.end >= probe.size {
+			seg.end = probe.size - 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := downloadSegmentWithRetry(c, req, probe, f, seg, progress, limiters); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := verifySegments(probe); err != nil {
+		return nil, err
+	}
+	log.Println("save file success: " + curlFlag.OutputFile)
+	return nil, nil
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Signer 在请求构建完成后、发送之前对请求进行签名
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// Credentials 签名所需的凭证
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// SignerScheme 描述一种签名方式
+type SignerScheme struct {
+	// 读取凭证的环境变量名
+	AccessKeyEnv    string
+	SecretKeyEnv    string
+	SessionTokenEnv string
+	// New 根据--sign <scheme>[:param]中的param与凭证创建签名器
+	New func(param string, cred Credentials) (Signer, error)
+}
+
+var signerSchemes = map[string]SignerScheme{}
+
+// RegisterSignerScheme 注册签名方式，供--sign选择
+func RegisterSignerScheme(name string, scheme SignerScheme) {
+	signerSchemes[strings.ToLower(name)] = scheme
+}
+
+func signerSchemeNames() []string {
+	names := make([]string, 0, len(signerSchemes))
+	for name := range signerSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterSignerScheme("aws-sigv4", SignerScheme{
+		AccessKeyEnv:    "AWS_ACCESS_KEY_ID",
+		SecretKeyEnv:    "AWS_SECRET_ACCESS_KEY",
+		SessionTokenEnv: "AWS_SESSION_TOKEN",
+		New:             newAWSSigV4Signer,
+	})
+	RegisterSignerScheme("qbox", SignerScheme{
+		AccessKeyEnv: "QINIU_ACCESS_KEY",
+		SecretKeyEnv: "QINIU_SECRET_KEY",
+		New:          newQBoxSigner,
+	})
+	RegisterSignerScheme("qiniu", SignerScheme{
+		AccessKeyEnv: "QINIU_ACCESS_KEY",
+		SecretKeyEnv: "QINIU_SECRET_KEY",
+		New:          newQiniuSigner,
+	})
+	RegisterSignerScheme("hmac", SignerScheme{
+		AccessKeyEnv: "CURL_GO_ACCESS_KEY",
+		SecretKeyEnv: "CURL_GO_SECRET_KEY",
+		New:          newHMACSigner,
+	})
+	RegisterSignerScheme("jwt", SignerScheme{
+		AccessKeyEnv: "CURL_GO_ACCESS_KEY",
+		SecretKeyEnv: "CURL_GO_JWT_SECRET",
+		New:          newJWTSigner,
+	})
+}
+
+func defaultCredentialsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".curl-go", "credentials")
+}
+
+// loadProfileCredentials 从INI格式的凭证文件读取指定profile
+//
+//	[default]
+//	access_key = AK
+//	secret_key = SK
+func loadProfileCredentials(r io.Reader, profile string) (cred Credentials, found bool, err error) {
+	scanner := bufio.NewScanner(r)
+	section := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(strings.TrimPrefix(line[1:len(line)-1], "profile "))
+			if section == profile {
+				found = true
+			}
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return cred, found, fmt.Errorf("invalid credentials line: %s", line)
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "access_key", "aws_access_key_id":
+			cred.AccessKey = strings.TrimSpace(v)
+		case "secret_key", "aws_secret_access_key":
+			cred.SecretKey = strings.TrimSpace(v)
+		case "session_token", "aws_session_token":
+			cred.SessionToken = strings.TrimSpace(v)
+		}
+	}
+	return cred, found, scanner.Err()
+}
+
+// loadCredentials 依次从--user、环境变量、凭证文件读取凭证，先读到的优先
+func loadCredentials(scheme SignerScheme) (Credentials, error) {
+	var cred Credentials
+	if curlFlag.User != "" {
+		cred.AccessKey, cred.SecretKey, _ = strings.Cut(curlFlag.User, ":")
+	}
+
+	fill := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
+		}
+	}
+	if scheme.AccessKeyEnv != "" {
+		fill(&cred.AccessKey, os.Getenv(scheme.AccessKeyEnv))
+	}
+	if scheme.SecretKeyEnv != "" {
+		fill(&cred.SecretKey, os.Getenv(scheme.SecretKeyEnv))
+	}
+	if scheme.SessionTokenEnv != "" {
+		fill(&cred.SessionToken, os.Getenv(scheme.SessionTokenEnv))
+	}
+	if cred.AccessKey != "" && cred.SecretKey != "" && curlFlag.Profile == "" {
+		return cred, nil
+	}
+
+	profile := curlFlag.Profile
+	if profile == "" {
+		profile = os.Getenv("CURL_GO_PROFILE")
+	}
+	explicit := profile != ""
+	if profile == "" {
+		profile = "default"
+	}
+	filename := curlFlag.CredentialsFile
+	if filename == "" {
+		filename = defaultCredentialsFile()
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		if explicit {
+			return cred, fmt.Errorf("read credentials file: %s error: %s", filename, err.Error())
+		}
+		return cred, nil
+	}
+	defer f.Close()
+
+	fileCred, found, err := loadProfileCredentials(f, profile)
+	if err != nil {
+		return cred, err
+	}
+	if !found {
+		if explicit {
+			return cred, fmt.Errorf("profile: %s not found in credentials file: %s", profile, filename)
+		}
+		return cred, nil
+	}
+	log.Tracef("load credentials from file: %s, profile: %s", filename, profile)
+	fill(&cred.AccessKey, fileCred.AccessKey)
+	fill(&cred.SecretKey, fileCred.SecretKey)
+	fill(&cred.SessionToken, fileCred.SessionToken)
+	return cred, nil
+}
+
+// buildSigner 根据--sign或--aws-sigv4创建签名器，未指定签名方式时返回nil
+func buildSigner() (Signer, error) {
+	name, param, _ := strings.Cut(curlFlag.Sign, ":")
+	if curlFlag.AwsSigV4 != "" {
+		if name != "" && name != "aws-sigv4" {
+			return nil, errors.New("--aws-sigv4 can not be used with --sign " + curlFlag.Sign)
+		}
+		name, param = "aws-sigv4", curlFlag.AwsSigV4
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	scheme, ok := signerSchemes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sign scheme: %s, valid schemes: %s", name, strings.Join(signerSchemeNames(), ", "))
+	}
+	cred, err := loadCredentials(scheme)
+	if err != nil {
+		return nil, err
+	}
+	log.Tracef("sign request with scheme: %s", name)
+	return scheme.New(param, cred)
+}
+
+// signRequest 按指定的签名方式对请求进行签名
+func signRequest(req *http.Request) error {
+	signer, err := buildSigner()
+	if err != nil || signer == nil {
+		return err
+	}
+	return signer.Sign(req)
+}
+
+// readReplayableBody 读取可重放的body用于计算签名，body不可重放时返回错误
+func readReplayableBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("can not sign request body which can not be read twice, such as stdin")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// hmacSigner 通用HMAC鉴权，待签名字符串为:
+//
+//	METHOD\n
+//	PATH[?QUERY]\n
+//	Content-Type\n
+//	X-Date\n
+//	hex(sha256(body))
+//
+// 签名结果为 Authorization: HMAC-SHA256 <AK>:<base64(signature)>
+type hmacSigner struct {
+	cred    Credentials
+	algo    string
+	newHash func() hash.Hash
+	now     func() time.Time
+}
+
+func newHMACSigner(param string, cred Credentials) (Signer, error) {
+	if cred.AccessKey == "" || cred.SecretKey == "" {
+		return nil, errors.New("hmac sign requires credentials, use --user AK:SK or CURL_GO_ACCESS_KEY/CURL_GO_SECRET_KEY")
+	}
+	algo := strings.ToLower(param)
+	if algo == "" {
+		algo = "sha256"
+	}
+	newHash, ok := hmacHashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hmac algorithm: %s, valid algorithms: sha1, sha256, sha384, sha512", param)
+	}
+	return &hmacSigner{cred: cred, algo: algo, newHash: newHash, now: time.Now}, nil
+}
+
+func (s *hmacSigner) Sign(req *http.Request) error {
+	if req.Header.Get("X-Date") == "" {
+		req.Header.Set("X-Date", s.now().UTC().Format(http.TimeFormat))
+	}
+
+	// body不可重放时不对body签名
+	payloadHash := sigV4UnsignedPayload
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		body, err := readReplayableBody(req)
+		if err != nil {
+			return err
+		}
+		payloadHash = sha256Hex(body)
+	}
+	req.Header.Set("X-Content-Sha256", payloadHash)
+
+	path := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	data := strings.Join([]string{
+		req.Method,
+		path,
+		req.Header.Get("Content-Type"),
+		req.Header.Get("X-Date"),
+		payloadHash,
+	}, "\n")
+	log.Tracef("hmac sign data:\n%s", data)
+
+	h := hmac.New(s.newHash, []byte(s.cred.SecretKey))
+	h.Write([]byte(data))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	req.Header.Set("Authorization", fmt.Sprintf("HMAC-%s %s:%s", strings.ToUpper(s.algo), s.cred.AccessKey, signature))
+	log.Trace("add header: Authorization: " + req.Header.Get("Authorization"))
+	return nil
+}
+
+var jwtHashes = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// jwtSigner 使用secret key签发JWT，Authorization: Bearer <JWT>
+type jwtSigner struct {
+	cred    Credentials
+	alg     string
+	newHash func() hash.Hash
+	now     func() time.Time
+}
+
+func newJWTSigner(param string, cred Credentials) (Signer, error) {
+	if cred.SecretKey == "" {
+		return nil, errors.New("jwt sign requires secret key, use --user [ISS]:SECRET or CURL_GO_JWT_SECRET")
+	}
+	alg := strings.ToUpper(param)
+	if alg == "" {
+		alg = "HS256"
+	}
+	newHash, ok := jwtHashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported jwt algorithm: %s, valid algorithms: HS256, HS384, HS512", param)
+	}
+	return &jwtSigner{cred: cred, alg: alg, newHash: newHash, now: time.Now}, nil
+}
+
+func (s *jwtSigner) Sign(req *http.Request) error {
+	now := s.now()
+	claims := map[string]any{
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(curlFlag.JWTExpire * float64(time.Second))).Unix(),
+	}
+	if s.cred.AccessKey != "" {
+		claims["iss"] = s.cred.AccessKey
+	}
+	// --jwt-claims中的字段覆盖默认字段
+	if curlFlag.JWTClaims != "" {
+		if err := json.Unmarshal([]byte(curlFlag.JWTClaims), &claims); err != nil {
+			return fmt.Errorf("invalid jwt claims: %s (%s)", curlFlag.JWTClaims, err.Error())
+		}
+	}
+
+	encode := func(v any) (string, error) {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(bs), nil
+	}
+	header, err := encode(map[string]string{"alg": s.alg, "typ": "JWT"})
+	if err != nil {
+		return err
+	}
+	payload, err := encode(claims)
+	if err != nil {
+		return err
+	}
+
+	h := hmac.New(s.newHash, []byte(s.cred.SecretKey))
+	h.Write([]byte(header + "." + payload))
+	token := header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+	req.Header.Set("Authorization", "Bearer "+token)
+	log.Trace("add header: Authorization: Bearer " + token)
+	return nil
+}
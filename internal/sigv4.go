@@ -0,0 +1,436 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sigV4UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	sigV4StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4TimeFormat       = "20060102T150405Z"
+	// aws-chunked 每个分块的大小
+	sigV4ChunkSize = 64 * 1024
+)
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// sigV4Signer 实现curl --aws-sigv4 "provider1[:provider2[:region[:service]]]"
+type sigV4Signer struct {
+	// provider1, 如aws，决定算法名称与签名key前缀
+	provider1 string
+	// provider2, 如amz，决定x-amz-*请求头前缀
+	provider2 string
+	region    string
+	service   string
+	cred      Credentials
+	now       func() time.Time
+}
+
+func newSigV4Signer(param string, cred Credentials) (*sigV4Signer, error) {
+	parts := strings.Split(param, ":")
+	if len(parts) > 4 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid aws-sigv4 param: %s, format: provider1[:provider2[:region[:service]]]", param)
+	}
+	s := &sigV4Signer{provider1: strings.ToLower(parts[0]), now: time.Now, cred: cred}
+	s.provider2 = s.provider1
+	if len(parts) > 1 && parts[1] != "" {
+		s.provider2 = strings.ToLower(parts[1])
+	}
+	if len(parts) > 2 {
+		s.region = parts[2]
+	}
+	if len(parts) > 3 {
+		s.service = parts[3]
+	}
+	if cred.AccessKey == "" || cred.SecretKey == "" {
+		return nil, errors.New("aws-sigv4 requires credentials, use --user AK:SK or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func (s *sigV4Signer) algorithm() string {
+	return strings.ToUpper(s.provider1) + "4-HMAC-SHA256"
+}
+
+func (s *sigV4Signer) headerPrefix() string {
+	return "X-" + strings.ToUpper(s.provider2[:1]) + s.provider2[1:] + "-"
+}
+
+// resolveScope 未显式指定region与service时按curl从host推断，如 s3.us-east-1.amazonaws.com
+func (s *sigV4Signer) resolveScope(host string) (region, service string, err error) {
+	region, service = s.region, s.service
+	if region != "" && service != "" {
+		return
+	}
+	labels := strings.Split(strings.Split(host, ":")[0], ".")
+	if service == "" {
+		service = labels[0]
+	}
+	if region == "" {
+		if len(labels) < 3 {
+			return "", "", fmt.Errorf("can not get region from host: %s, use --aws-sigv4 provider1:provider2:region:service", host)
+		}
+		region = labels[1]
+	}
+	return
+}
+
+// awsURIEncode 按AWS规则编码，仅保留unreserved字符
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func (s *sigV4Signer) canonicalURI(u *url.URL, service string) string {
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	uri := awsURIEncode(path, false)
+	// 除s3外的服务需要二次编码
+	if service != "s3" {
+		uri = awsURIEncode(uri, false)
+	}
+	return uri
+}
+
+func canonicalQuery(u *url.URL) string {
+	var pairs []string
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4SkipHeaders 不参与签名的请求头
+var sigV4SkipHeaders = map[string]bool{
+	"authorization":     true,
+	"user-agent":        true,
+	"expect":            true,
+	"content-length":    true,
+	"transfer-encoding": true,
+	"x-amzn-trace-id":   true,
+}
+
+func (s *sigV4Signer) canonicalHeaders(req *http.Request) (canonical, signed string) {
+	headers := map[string]string{}
+	for k, vs := range req.Header {
+		name := strings.ToLower(k)
+		if sigV4SkipHeaders[name] || name == "host" {
+			continue
+		}
+		values := make([]string, 0, len(vs))
+		for _, v := range vs {
+			values = append(values, strings.Join(strings.Fields(v), " "))
+		}
+		headers[name] = strings.Join(values, ",")
+	}
+	headers["host"] = req.Host
+	if headers["host"] == "" {
+		headers["host"] = req.URL.Host
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return sb.String(), strings.Join(names, ";")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sigV4Signer) signingKey(date, region, service string) []byte {
+	k := hmacSHA256([]byte(strings.ToUpper(s.provider1)+"4"+s.cred.SecretKey), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, s.provider1+"4_request")
+}
+
+func isChunkedRequest(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Transfer-Encoding"), "chunked") || req.ContentLength < 0
+}
+
+// payloadHash 计算body的sha256，body不可重放或为chunked传输时使用UNSIGNED-PAYLOAD
+func (s *sigV4Signer) payloadHash(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return emptySHA256, nil
+	}
+	if req.GetBody == nil || isChunkedRequest(req) {
+		return sigV4UnsignedPayload, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign 对请求进行签名，添加Authorization等请求头
+func (s *sigV4Signer) Sign(req *http.Request) error {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	region, service, err := s.resolveScope(host)
+	if err != nil {
+		return err
+	}
+
+	prefix := s.headerPrefix()
+	dateHeader := prefix + "Date"
+	amzDate := req.Header.Get(dateHeader)
+	if amzDate == "" {
+		amzDate = s.now().UTC().Format(sigV4TimeFormat)
+		req.Header.Set(dateHeader, amzDate)
+	}
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %s", dateHeader, amzDate)
+	}
+	date := t.Format("20060102")
+	scope := strings.Join([]string{date, region, service, s.provider1 + "4_request"}, "/")
+
+	if s.cred.SessionToken != "" {
+		req.Header.Set(prefix+"Security-Token", s.cred.SessionToken)
+	}
+
+	// 用户显式指定的payload hash优先
+	contentSHA256Header := prefix + "Content-Sha256"
+	payloadHash := req.Header.Get(contentSHA256Header)
+	if payloadHash == "" {
+		if payloadHash, err = s.payloadHash(req); err != nil {
+			return err
+		}
+		if service == "s3" || payloadHash == sigV4UnsignedPayload {
+			req.Header.Set(contentSHA256Header, payloadHash)
+		}
+	}
+
+	streaming := payloadHash == sigV4StreamingPayload
+	if streaming {
+		if err := prepareAWSChunked(req, prefix); err != nil {
+			return err
+		}
+	}
+
+	canonicalHeaders, signedHeaders := s.canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL, service),
+		canonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	log.Tracef("aws-sigv4 canonical request:\n%s", canonicalRequest)
+
+	stringToSign := strings.Join([]string{
+		s.algorithm(),
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	log.Tracef("aws-sigv4 string to sign:\n%s", stringToSign)
+
+	key := s.signingKey(date, region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.algorithm(), s.cred.AccessKey, scope, signedHeaders, signature))
+	log.Trace("add header: Authorization: " + req.Header.Get("Authorization"))
+
+	if streaming {
+		chunkSigner := &awsChunkSigner{
+			algorithm: s.algorithm() + "-PAYLOAD",
+			amzDate:   amzDate,
+			scope:     scope,
+			key:       key,
+			seed:      signature,
+		}
+		getBody := req.GetBody
+		req.Body = chunkSigner.wrap(req.Body)
+		if getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return chunkSigner.wrap(body), nil
+			}
+		}
+	}
+	return nil
+}
+
+// prepareAWSChunked 设置aws-chunked编码所需的请求头与Content-Length
+func prepareAWSChunked(req *http.Request, prefix string) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return errors.New("streaming payload requires request body")
+	}
+	decodedLength := req.ContentLength
+	if decodedLength <= 0 {
+		if req.GetBody == nil {
+			return errors.New("streaming payload requires known content length")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		decodedLength, err = io.Copy(io.Discard, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if ce := req.Header.Get("Content-Encoding"); ce != "" && !strings.Contains(ce, "aws-chunked") {
+		req.Header.Set("Content-Encoding", "aws-chunked,"+ce)
+	} else {
+		req.Header.Set("Content-Encoding", "aws-chunked")
+	}
+	req.Header.Set(prefix+"Decoded-Content-Length", strconv.FormatInt(decodedLength, 10))
+	if !isChunkedRequest(req) {
+		req.ContentLength = awsChunkedLength(decodedLength)
+	}
+	return nil
+}
+
+func awsChunkedLength(decodedLength int64) int64 {
+	// hex(size);chunk-signature=<64>\r\n<data>\r\n
+	chunkLength := func(size int64) int64 {
+		return int64(len(strconv.FormatInt(size, 16))) + int64(len(";chunk-signature=")) + 64 + 2 + size + 2
+	}
+	full := decodedLength / sigV4ChunkSize
+	length := full * chunkLength(sigV4ChunkSize)
+	if rest := decodedLength % sigV4ChunkSize; rest > 0 {
+		length += chunkLength(rest)
+	}
+	return length + chunkLength(0)
+}
+
+// awsChunkSigner 将body编码为带签名的aws-chunked格式
+type awsChunkSigner struct {
+	algorithm string
+	amzDate   string
+	scope     string
+	key       []byte
+	seed      string
+}
+
+func (s *awsChunkSigner) wrap(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		var err error
+		defer func() { pw.CloseWithError(err) }()
+		defer body.Close()
+
+		prevSig := s.seed
+		writeChunk := func(chunk []byte) error {
+			stringToSign := strings.Join([]string{
+				s.algorithm, s.amzDate, s.scope, prevSig, emptySHA256, sha256Hex(chunk),
+			}, "\n")
+			prevSig = hex.EncodeToString(hmacSHA256(s.key, stringToSign))
+			if _, err := fmt.Fprintf(pw, "%x;chunk-signature=%s\r\n", len(chunk), prevSig); err != nil {
+				return err
+			}
+			if _, err := pw.Write(chunk); err != nil {
+				return err
+			}
+			_, err := io.WriteString(pw, "\r\n")
+			return err
+		}
+
+		buf := make([]byte, sigV4ChunkSize)
+		for {
+			n, rerr := io.ReadFull(body, buf)
+			last := rerr == io.EOF || rerr == io.ErrUnexpectedEOF
+			if rerr != nil && !last {
+				err = rerr
+				return
+			}
+			if n > 0 {
+				if err = writeChunk(buf[:n]); err != nil {
+					return
+				}
+			}
+			if last {
+				// 以空分块结束
+				err = writeChunk(nil)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// newAWSSigV4Signer 创建aws-sigv4签名器，param未指定region时使用AWS标准环境变量
+func newAWSSigV4Signer(param string, cred Credentials) (Signer, error) {
+	if param == "" {
+		param = "aws:amz"
+	}
+	if parts := strings.Split(param, ":"); len(parts) < 3 {
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		if region != "" {
+			for len(parts) < 2 {
+				parts = append(parts, "")
+			}
+			param = strings.Join(append(parts, region), ":")
+		}
+	}
+	signer, err := newSigV4Signer(param, cred)
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
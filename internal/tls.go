@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// openSSLCipherNames OpenSSL风格的cipher名称到Go cipher名称的映射
+var openSSLCipherNames = map[string]string{
+	"ECDHE-ECDSA-AES128-GCM-SHA256": "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"ECDHE-RSA-AES128-GCM-SHA256":   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"ECDHE-ECDSA-AES256-GCM-SHA384": "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"ECDHE-RSA-AES256-GCM-SHA384":   "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"ECDHE-ECDSA-CHACHA20-POLY1305": "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"ECDHE-RSA-CHACHA20-POLY1305":   "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	"ECDHE-ECDSA-AES128-SHA":        "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	"ECDHE-RSA-AES128-SHA":          "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	"ECDHE-ECDSA-AES256-SHA":        "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	"ECDHE-RSA-AES256-SHA":          "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	"ECDHE-ECDSA-AES128-SHA256":     "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	"ECDHE-RSA-AES128-SHA256":       "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	"AES128-GCM-SHA256":             "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	"AES256-GCM-SHA384":             "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	"AES128-SHA":                    "TLS_RSA_WITH_AES_128_CBC_SHA",
+	"AES256-SHA":                    "TLS_RSA_WITH_AES_256_CBC_SHA",
+	"AES128-SHA256":                 "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	"DES-CBC3-SHA":                  "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+}
+
+// parseCiphers 解析--ciphers，支持Go与OpenSSL两种命名，使用:或,分隔
+func parseCiphers(s string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[c.Name] = c.ID
+	}
+	var ids []uint16
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == ',' }) {
+		name = strings.TrimSpace(name)
+		if goName, ok := openSSLCipherNames[name]; ok {
+			name = goName
+		}
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// appendCertsFromPEMFile 将PEM文件中的证书加入pool
+func appendCertsFromPEMFile(pool *x509.CertPool, filename string) error {
+	bs, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(bs) {
+		return fmt.Errorf("no certificate found in: %s", filename)
+	}
+	log.Trace("load ca certificates from: " + filename)
+	return nil
+}
+
+func buildRootCAs() (*x509.CertPool, error) {
+	return loadCertPool(curlFlag.CACert, curlFlag.CAPath)
+}
+
+// loadCertPool 从CA证书文件与目录加载证书，都未指定时返回nil使用系统证书
+func loadCertPool(caFile, caPath string) (*x509.CertPool, error) {
+	if caFile == "" && caPath == "" {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if caFile != "" {
+		if err := appendCertsFromPEMFile(pool, caFile); err != nil {
+			return nil, err
+		}
+	}
+	if caPath != "" {
+		entries, err := os.ReadDir(caPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			// 目录中可能混有非证书文件，跳过即可
+			if err := appendCertsFromPEMFile(pool, filepath.Join(caPath, e.Name())); err != nil {
+				log.Debugf("skip file in capath: %s", err.Error())
+			}
+		}
+	}
+	return pool, nil
+}
+
+// splitCertPassword 按curl语义解析--cert <file[:password]>
+func splitCertPassword(s string) (string, string) {
+	if _, err := os.Stat(s); err == nil {
+		return s, ""
+	}
+	if idx := strings.LastIndex(s, ":"); idx > 0 {
+		return s[:idx], s[idx+1:]
+	}
+	return s, ""
+}
+
+func loadPEMKeyPair(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	// 解密加密的PEM私钥
+	if password != "" {
+		var out []byte
+		for rest := keyPEM; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			switch {
+			case block.Type == "ENCRYPTED PRIVATE KEY":
+				// PKCS#8加密私钥
+				key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+				if err != nil {
+					return tls.Certificate{}, fmt.Errorf("decrypt private key: %s error: %s", keyFile, err.Error())
+				}
+				der, err := x509.MarshalPKCS8PrivateKey(key)
+				if err != nil {
+					return tls.Certificate{}, err
+				}
+				block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+			case x509.IsEncryptedPEMBlock(block):
+				// 传统的PEM加密私钥（Proc-Type: 4,ENCRYPTED）没有完整性校验，Go已将其标记为不安全并废弃。
+				// openssl 1.0及 openssl rsa -des3 等命令仍会生成这种格式，curl也支持，因此仅为兼容保留，
+				// 新的私钥应使用上面的PKCS#8加密格式
+				der, err := x509.DecryptPEMBlock(block, []byte(password))
+				if err != nil {
+					return tls.Certificate{}, fmt.Errorf("decrypt private key: %s error: %s", keyFile, err.Error())
+				}
+				block = &pem.Block{Type: block.Type, Bytes: der}
+			}
+			out = append(out, pem.EncodeToMemory(block)...)
+		}
+		keyPEM = out
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func loadP12KeyPair(certFile, password string) (tls.Certificate, error) {
+	bs, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, cert, caCerts, err := pkcs12.DecodeChain(bs, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decode pkcs#12 file: %s error: %s", certFile, err.Error())
+	}
+	tlsCert := tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	for _, ca := range caCerts {
+		tlsCert.Certificate = append(tlsCert.Certificate, ca.Raw)
+	}
+	return tlsCert, nil
+}
+
+func loadClientCertificate() (*tls.Certificate, error) {
+	if curlFlag.Cert == "" {
+		return nil, nil
+	}
+	certFile, password := splitCertPassword(curlFlag.Cert)
+	if curlFlag.Pass != "" {
+		password = curlFlag.Pass
+	}
+
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	switch strings.ToUpper(curlFlag.CertType) {
+	case "", "PEM":
+		keyFile := curlFlag.Key
+		if keyFile == "" {
+			// 未指定--key时私钥与证书在同一个文件中
+			keyFile = certFile
+		}
+		cert, err = loadPEMKeyPair(certFile, keyFile, password)
+	case "P12", "PKCS12":
+		cert, err = loadP12KeyPair(certFile, password)
+	default:
+		return nil, fmt.Errorf("unsupported cert type: %s, valid types: PEM, P12", curlFlag.CertType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Trace("load client certificate: " + certFile)
+	return &cert, nil
+}
+
+// parsePinnedPubKey 解析--pinnedpubkey，返回所有允许的公钥sha256
+func parsePinnedPubKey(s string) ([][]byte, error) {
+	var hashes [][]byte
+	if !strings.HasPrefix(s, "sha256//") {
+		// 公钥文件，PEM或DER格式
+		bs, err := os.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+		if block, _ := pem.Decode(bs); block != nil {
+			bs = block.Bytes
+		}
+		if _, err := x509.ParsePKIXPublicKey(bs); err != nil {
+			return nil, fmt.Errorf("invalid pinned public key file: %s (%s)", s, err.Error())
+		}
+		sum := sha256.Sum256(bs)
+		return append(hashes, sum[:]), nil
+	}
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "sha256//")
+		hash, err := base64.StdEncoding.DecodeString(item)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid pinned public key: sha256//%s", item)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func verifyPinnedPubKey(hashes [][]byte) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("pinned public key: no peer certificate")
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+		for _, hash := range hashes {
+			if string(hash) == string(sum[:]) {
+				return nil
+			}
+		}
+		return fmt.Errorf("pinned public key mismatch, got: sha256//%s", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+}
+
+// buildTLSConfig 根据TLS相关flags构建tls.Config
+func buildTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: curlFlag.Insecure,
+	}
+
+	// 最低版本取显式指定的最高的那个
+	for _, v := range []struct {
+		set     bool
+		version uint16
+	}{
+		{curlFlag.TLSv10, tls.VersionTLS10},
+		{curlFlag.TLSv11, tls.VersionTLS11},
+		{curlFlag.TLSv12, tls.VersionTLS12},
+		{curlFlag.TLSv13, tls.VersionTLS13},
+	} {
+		if v.set {
+			config.MinVersion = v.version
+		}
+	}
+	if curlFlag.TLSMax != "" && curlFlag.TLSMax != "default" {
+		max, ok := tlsVersions[curlFlag.TLSMax]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls max version: %s, valid versions: 1.0, 1.1, 1.2, 1.3, default", curlFlag.TLSMax)
+		}
+		if config.MinVersion > max {
+			return nil, fmt.Errorf("tls max version %s is lower than min version", curlFlag.TLSMax)
+		}
+		config.MaxVersion = max
+	}
+
+	if curlFlag.Ciphers != "" {
+		ids, err := parseCiphers(curlFlag.Ciphers)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = ids
+	}
+
+	rootCAs, err := buildRootCAs()
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = rootCAs
+
+	cert, err := loadClientCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	if curlFlag.PinnedPubKey != "" {
+		hashes, err := parsePinnedPubKey(curlFlag.PinnedPubKey)
+		if err != nil {
+			return nil, err
+		}
+		// 即使-k跳过了证书校验，依旧校验公钥
+		config.VerifyConnection = verifyPinnedPubKey(hashes)
+	}
+	return config, nil
+}
@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// setFlags 替换全局的curlFlag，测试结束后恢复
+func setFlags(t *testing.T, f *Flags) {
+	t.Helper()
+	old := curlFlag
+	curlFlag = f
+	t.Cleanup(func() { curlFlag = old })
+}
+
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// serverCertPEM 返回httptest服务端证书的PEM
+func serverCertPEM(srv *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+// newClientCert 生成自签名的客户端证书
+func newClientCert(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "curl-go client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// tlsGet 使用buildTLSConfig的配置请求url
+func tlsGet(t *testing.T, url string) (*http.Response, error) {
+	t.Helper()
+	cfg, err := buildTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &http.Transport{TLSClientConfig: cfg}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func TestTLSVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	caFile := writeTempFile(t, "ca.pem", serverCertPEM(srv))
+
+	tests := []struct {
+		name    string
+		flags   *Flags
+		wantErr bool
+	}{
+		{"untrusted", &Flags{}, true},
+		{"insecure", &Flags{Insecure: true}, false},
+		{"cacert", &Flags{CACert: caFile}, false},
+		{"capath", &Flags{CAPath: filepath.Dir(caFile)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.flags)
+			_, err := tlsGet(t, srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSVersion(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	setFlags(t, &Flags{Insecure: true, TLSMax: "1.2"})
+	resp, err := tlsGet(t, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TLS.Version != tls.VersionTLS12 {
+		t.Fatalf("tls version = %x, want TLS 1.2", resp.TLS.Version)
+	}
+
+	setFlags(t, &Flags{TLSv13: true, TLSMax: "1.2"})
+	if _, err := buildTLSConfig(); err == nil {
+		t.Fatal("expect error when --tls-max is lower than min version")
+	}
+}
+
+func TestParseCiphers(t *testing.T) {
+	ids, err := parseCiphers("ECDHE-RSA-AES128-GCM-SHA256:TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if len(ids) != len(want) || ids[0] != want[0] || ids[1] != want[1] {
+		t.Fatalf("ciphers = %v, want %v", ids, want)
+	}
+	if _, err := parseCiphers("NO-SUCH-CIPHER"); err == nil {
+		t.Fatal("expect error for unknown cipher")
+	}
+}
+
+func TestPinnedPubKey(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	sum := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pin := "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+	other := "sha256//" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	der, err := x509.MarshalPKIXPublicKey(srv.Certificate().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeTempFile(t, "pub.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"match", pin, false},
+		{"match one of", other + ";" + pin, false},
+		{"mismatch", other, true},
+		{"public key file", keyFile, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// -k 不影响公钥校验
+			setFlags(t, &Flags{Insecure: true, PinnedPubKey: tt.pin})
+			_, err := tlsGet(t, srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert, key := newClientCert(t)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "curl-go client" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	certFile := writeTempFile(t, "client.pem", certPEM)
+	keyFile := writeTempFile(t, "client.key", keyPEM)
+	bundleFile := writeTempFile(t, "bundle.pem", append(append([]byte(nil), certPEM...), keyPEM...))
+	encDER, err := pkcs8.ConvertPrivateKeyToPKCS8(key, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encKeyFile := writeTempFile(t, "client.enc.key", pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encDER}))
+	p12, err := pkcs12.Modern.Encode(key, cert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12File := writeTempFile(t, "client.p12", p12)
+
+	tests := []struct {
+		name    string
+		flags   *Flags
+		wantErr bool
+	}{
+		{"no cert", &Flags{}, true},
+		{"pem with key", &Flags{Cert: certFile, Key: keyFile}, false},
+		{"pem bundle", &Flags{Cert: bundleFile}, false},
+		{"encrypted pkcs8 key", &Flags{Cert: certFile + ":secret", Key: encKeyFile}, false},
+		{"p12 with password", &Flags{Cert: p12File + ":secret", CertType: "P12"}, false},
+		{"p12 with --pass", &Flags{Cert: p12File, Pass: "secret", CertType: "P12"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.flags.Insecure = true
+			setFlags(t, tt.flags)
+			resp, err := tlsGet(t, srv.URL)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expect handshake error without client certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+
+	setFlags(t, &Flags{Cert: p12File + ":wrong", CertType: "P12"})
+	if _, err := buildTLSConfig(); err == nil {
+		t.Fatal("expect error for wrong p12 password")
+	}
+	setFlags(t, &Flags{Cert: certFile + ":wrong", Key: encKeyFile})
+	if _, err := buildTLSConfig(); err == nil {
+		t.Fatal("expect error for wrong private key password")
+	}
+}
@@ -10,7 +10,7 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func BuildClientTrace() *httptrace.ClientTrace {
+func BuildClientTrace(transfer *TransferInfo) *httptrace.ClientTrace {
 	var (
 		getConnTime      time.Time
 		dnsStartTime     time.Time
@@ -21,6 +21,7 @@ func BuildClientTrace() *httptrace.ClientTrace {
 		GetConn: func(hostPort string) {
 			log.Tracef("[GetConn] HostPort: %s", hostPort)
 			getConnTime = time.Now()
+			transfer.onGetConn()
 		},
 
 		GotConn: func(info httptrace.GotConnInfo) {
@@ -32,6 +33,7 @@ func BuildClientTrace() *httptrace.ClientTrace {
 				log.Tracef("[GotConn] IdleTime: %#v", info.IdleTime)
 			}
 			log.Tracef("[GotConn] Duration: %s", time.Since(getConnTime).String())
+			transfer.onGotConn(info.Conn)
 		},
 
 		PutIdleConn: func(err error) {
@@ -40,6 +42,7 @@ func BuildClientTrace() *httptrace.ClientTrace {
 
 		GotFirstResponseByte: func() {
 			log.Tracef("[GotFirstResponseByte]")
+			transfer.onFirstResponseByte()
 		},
 
 		Got100Continue: func() {
@@ -60,6 +63,7 @@ func BuildClientTrace() *httptrace.ClientTrace {
 			log.Tracef("[DNSDone] Coalesced: %v", info.Coalesced)
 			log.Tracef("[DNSDone] Error: %v", info.Err)
 			log.Tracef("[DNSDone] Duration: %s", time.Since(dnsStartTime).String())
+			transfer.onDNSDone()
 		},
 
 		ConnectStart: func(network, addr string) {
@@ -73,6 +77,7 @@ func BuildClientTrace() *httptrace.ClientTrace {
 			log.Tracef("[ConnectDone] Addr: %s", addr)
 			log.Tracef("[ConnectDone] Error: %v", err)
 			log.Tracef("[ConnectDone] Duration: %s", time.Since(connectTime).String())
+			transfer.onConnectDone(err)
 		},
 
 		WroteHeaders: func() {
@@ -99,6 +104,7 @@ func BuildClientTrace() *httptrace.ClientTrace {
 			log.Tracef("[TLSHandshakeDone] ServerName: %s", cs.ServerName)
 			log.Tracef("[TLSHandshakeDone] Error: %v", err)
 			log.Tracef("[TLSHandshakeDone] Duration: %s", time.Since(tlsHandshakeTime).String())
+			transfer.onTLSHandshakeDone()
 		},
 		WroteHeaderField: func(key string, value []string) {
 			log.Tracef("[WroteHeaderField] key: %s, value: %v", key, strings.Join(value, ","))
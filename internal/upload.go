@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expandGlob 展开-T中的 {a,b} 与 [1-3]、[a-c]，与curl的url globbing一致
+func expandGlob(pattern string) ([]string, error) {
+	i := strings.IndexAny(pattern, "{[")
+	if i < 0 {
+		return []string{pattern}, nil
+	}
+	var alternatives []string
+	switch pattern[i] {
+	case '{':
+		end := strings.IndexByte(pattern[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unmatched brace in glob: %s", pattern)
+		}
+		alternatives = strings.Split(pattern[i+1:i+end], ",")
+		pattern = pattern[:i] + "\x00" + pattern[i+end+1:]
+	case '[':
+		end := strings.IndexByte(pattern[i:], ']')
+		if end < 0 {
+			return nil, fmt.Errorf("unmatched bracket in glob: %s", pattern)
+		}
+		r, err := expandRange(pattern[i+1 : i+end])
+		if err != nil {
+			return nil, err
+		}
+		alternatives = r
+		pattern = pattern[:i] + "\x00" + pattern[i+end+1:]
+	}
+	prefix, suffix, _ := strings.Cut(pattern, "\x00")
+	rests, err := expandGlob(suffix)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, alt := range alternatives {
+		for _, rest := range rests {
+			result = append(result, prefix+alt+rest)
+		}
+	}
+	return result, nil
+}
+
+// expandRange 展开 1-3、01-10 或 a-c，数字前导0的宽度保持一致
+func expandRange(r string) ([]string, error) {
+	from, to, ok := strings.Cut(r, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid glob range: [%s]", r)
+	}
+	if len(from) == 1 && len(to) == 1 && !isDigit(from[0]) && !isDigit(to[0]) && from[0] <= to[0] {
+		var result []string
+		for c := from[0]; c <= to[0]; c++ {
+			result = append(result, string(c))
+		}
+		return result, nil
+	}
+	start, err1 := strconv.Atoi(from)
+	end, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || start > end {
+		return nil, fmt.Errorf("invalid glob range: [%s]", r)
+	}
+	width := 0
+	if strings.HasPrefix(from, "0") {
+		width = len(from)
+	}
+	var result []string
+	for n := start; n <= end; n++ {
+		result = append(result, fmt.Sprintf("%0*d", width, n))
+	}
+	return result, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// uploadURL url没有路径或以/结尾时，追加上传的文件名
+func uploadURL(urlStr, file string) string {
+	if file == "-" {
+		return urlStr
+	}
+	rest := urlStr
+	if _, after, ok := strings.Cut(rest, "://"); ok {
+		rest = after
+	}
+	var query string
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest, query = rest[:i], rest[i:]
+	}
+	base := url.PathEscape(filepath.Base(file))
+	switch {
+	case !strings.Contains(rest, "/"):
+		return strings.TrimSuffix(urlStr, query) + "/" + base + query
+	case strings.HasSuffix(rest, "/"):
+		return strings.TrimSuffix(urlStr, query) + base + query
+	}
+	return urlStr
+}
+
+// setUploadBody -T 上传文件，-表示以chunked编码流式上传stdin
+func setUploadBody(req *http.Request, filename string) error {
+	log.Trace("upload file: " + filename)
+	if filename == "-" {
+		req.ContentLength = -1
+		req.Body = io.NopCloser(os.Stdin)
+		return nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	req.Body = f
+	req.ContentLength = stat.Size()
+	// 重定向与重试时需要重新读取文件
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(filename)
+	}
+	return nil
+}
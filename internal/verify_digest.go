@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expectedDigest 响应中声明的一个摘要
+type expectedDigest struct {
+	source    string
+	algorithm string
+	sum       []byte
+}
+
+// digestVerifier 在输出body的同时计算摘要，读取完成后与header或trailer中的摘要比较
+type digestVerifier struct {
+	resp   *http.Response
+	hashes map[string]hash.Hash
+	eof    bool
+}
+
+// newDigestVerifier 根据响应中出现的header选择需要计算的摘要，没有可校验的摘要时返回nil
+func newDigestVerifier(resp *http.Response) *digestVerifier {
+	if resp.Uncompressed {
+		log.Warn("verify digest: response is decompressed, skip verification")
+		return nil
+	}
+	has := func(key string) bool {
+		_, inHeader := resp.Header[key]
+		_, inTrailer := resp.Trailer[key]
+		return inHeader || inTrailer
+	}
+	needed := map[string]bool{}
+	if has("Content-Md5") || md5ETag(resp.Header.Get("ETag")) != nil {
+		needed["md5"] = true
+	}
+	if has("X-Amz-Checksum-Sha256") {
+		needed["sha-256"] = true
+	}
+	if has("X-Amz-Checksum-Crc32c") {
+		needed["crc32c"] = true
+	}
+	if has("Content-Digest") || has("Repr-Digest") {
+		// trailer中的算法要到读取完成后才知道
+		needed["sha-256"], needed["sha-512"] = true, true
+	}
+	if len(needed) == 0 {
+		log.Warn("verify digest: no Content-MD5, Content-Digest or MD5 ETag in response")
+		return nil
+	}
+
+	v := &digestVerifier{resp: resp, hashes: map[string]hash.Hash{}}
+	var writers []io.Writer
+	for name := range needed {
+		h := digestHashes[name]()
+		v.hashes[name] = h
+		writers = append(writers, h)
+	}
+	tee := io.TeeReader(&eofReader{r: resp.Body, eof: &v.eof}, io.MultiWriter(writers...))
+	resp.Body = &readCloser{Reader: tee, Closer: resp.Body}
+	return v
+}
+
+// verify 比较摘要，不一致时按--keep-bad-output决定是否删除-o的文件
+func (v *digestVerifier) verify() error {
+	if v == nil {
+		return nil
+	}
+	if !v.eof {
+		log.Warn("verify digest: response body is not fully read, skip verification")
+		return nil
+	}
+	var (
+		errs     []error
+		verified int
+	)
+	for _, e := range v.expected() {
+		h, ok := v.hashes[e.algorithm]
+		if !ok {
+			continue
+		}
+		verified++
+		sum := h.Sum(nil)
+		if !bytes.Equal(sum, e.sum) {
+			errs = append(errs, fmt.Errorf("%s %s mismatch, expected %s, got %s",
+				e.source, e.algorithm, base64Digest(e.sum), base64Digest(sum)))
+			continue
+		}
+		log.Debugf("verify digest: %s %s ok", e.source, e.algorithm)
+	}
+	if verified == 0 {
+		log.Warn("verify digest: no supported digest in response")
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	err := errors.Join(errs...)
+	log.Error("verify digest: ", err)
+	if curlFlag.OutputFile != "" {
+		if curlFlag.KeepBadOutput {
+			log.Warn("keep bad output file: " + curlFlag.OutputFile)
+		} else if rerr := os.Remove(curlFlag.OutputFile); rerr != nil {
+			log.Error("remove bad output file error: ", rerr)
+		} else {
+			log.Warn("remove bad output file: " + curlFlag.OutputFile)
+		}
+	}
+	return &ExitError{Code: ExitCodeDigestMismatch, Err: err}
+}
+
+// expected 读取完成后收集header与trailer中的摘要
+func (v *digestVerifier) expected() []expectedDigest {
+	resp := v.resp
+	get := func(key string) string {
+		if value := resp.Header.Get(key); value != "" {
+			return value
+		}
+		return resp.Trailer.Get(key)
+	}
+	var expected []expectedDigest
+	addBase64 := func(source, algorithm string) {
+		value := get(source)
+		if value == "" {
+			return
+		}
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			log.Warnf("verify digest: invalid %s: %s", source, value)
+			return
+		}
+		expected = append(expected, expectedDigest{source: source, algorithm: algorithm, sum: sum})
+	}
+	addBase64("Content-MD5", "md5")
+	addBase64("X-Amz-Checksum-Sha256", "sha-256")
+	addBase64("X-Amz-Checksum-Crc32c", "crc32c")
+	expected = append(expected, parseDigestField("Content-Digest", get("Content-Digest"))...)
+	// 206响应只包含部分内容，Repr-Digest与ETag对应完整的资源
+	if resp.StatusCode == http.StatusOK {
+		expected = append(expected, parseDigestField("Repr-Digest", get("Repr-Digest"))...)
+		if sum := md5ETag(resp.Header.Get("ETag")); sum != nil {
+			expected = append(expected, expectedDigest{source: "ETag", algorithm: "md5", sum: sum})
+		}
+	}
+	return expected
+}
+
+// parseDigestField 解析RFC 9530的 sha-256=:base64:, sha-512=:base64:
+func parseDigestField(source, value string) []expectedDigest {
+	var expected []expectedDigest
+	for _, item := range strings.Split(value, ",") {
+		algorithm, sum, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if _, supported := digestHashes[algorithm]; !supported {
+			log.Debugf("verify digest: unsupported %s algorithm: %s", source, algorithm)
+			continue
+		}
+		bs, err := base64.StdEncoding.DecodeString(strings.Trim(strings.TrimSpace(sum), ":"))
+		if err != nil {
+			log.Warnf("verify digest: invalid %s: %s", source, item)
+			continue
+		}
+		expected = append(expected, expectedDigest{source: source, algorithm: algorithm, sum: bs})
+	}
+	return expected
+}
+
+// eofReader 记录是否读取到EOF
+type eofReader struct {
+	r   io.Reader
+	eof *bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		*r.eof = true
+	}
+	return n, err
+}
@@ -0,0 +1,381 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhangzqs/curl-go/internal/version"
+)
+
+// TransferInfo 记录一次传输(含重定向)的统计信息，用于--write-out
+type TransferInfo struct {
+	mu sync.Mutex
+
+	start time.Time
+	// 以下时间均相对于start，即第一跳开始的时间，与curl一致包含重定向的耗时
+	hopStart      time.Duration
+	namelookup    time.Duration
+	connect       time.Duration
+	appconnect    time.Duration
+	pretransfer   time.Duration
+	starttransfer time.Duration
+	total         time.Duration
+
+	hops        int
+	numRetries  int
+	numConnects int
+	localAddr   net.Addr
+	remoteAddr  net.Addr
+
+	URL          string
+	SizeDownload int64
+	SizeUpload   int64
+
+	Request  *http.Request
+	Response *http.Response
+	Err      error
+}
+
+func NewTransferInfo() *TransferInfo {
+	return &TransferInfo{start: time.Now()}
+}
+
+func (t *TransferInfo) since() time.Duration {
+	return time.Since(t.start)
+}
+
+// 以下方法由BuildClientTrace在各阶段调用
+
+func (t *TransferInfo) onGetConn() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hops++
+	t.hopStart = t.since()
+	// 复用连接时不会触发DNS、连接与TLS事件，这些阶段视为耗时为0
+	t.namelookup, t.connect, t.appconnect = t.hopStart, t.hopStart, 0
+	t.pretransfer, t.starttransfer = 0, 0
+}
+
+func (t *TransferInfo) onDNSDone() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.namelookup = t.since()
+}
+
+func (t *TransferInfo) onConnectDone(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.numConnects++
+		t.connect = t.since()
+	}
+}
+
+func (t *TransferInfo) onTLSHandshakeDone() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.appconnect = t.since()
+}
+
+func (t *TransferInfo) onGotConn(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.localAddr, t.remoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+	t.pretransfer = t.since()
+}
+
+func (t *TransferInfo) onFirstResponseByte() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.starttransfer = t.since()
+}
+
+// onRetry 在重试前调用，重试后重新统计重定向次数
+func (t *TransferInfo) onRetry() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.numRetries++
+	t.hops = 0
+}
+
+// Finish 在传输结束后调用，记录总耗时
+func (t *TransferInfo) Finish(resp *http.Response, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = t.since()
+	if resp != nil {
+		t.Response = resp
+		t.Request = resp.Request
+	}
+	t.Err = err
+}
+
+// WrapRequest 统计请求body的上传字节数。重定向、重试与HTTP/3回退时通过GetBody重新生成的body
+// 从0开始重新统计，与size_download一致，size_upload只表示最后一次请求上传的字节数
+func (t *TransferInfo) WrapRequest(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	req.Body = &countingReadCloser{ReadCloser: req.Body, n: &t.SizeUpload}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			t.SizeUpload = 0
+			return &countingReadCloser{ReadCloser: body, n: &t.SizeUpload}, nil
+		}
+	}
+}
+
+// countingReadCloser 统计读取的字节数
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+func splitAddr(addr net.Addr) (string, int) {
+	if addr == nil {
+		return "", 0
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String(), 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func seconds(d time.Duration) float64 {
+	return d.Seconds()
+}
+
+// Variables 返回curl --write-out支持的所有变量
+func (t *TransferInfo) Variables() map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	remoteIP, remotePort := splitAddr(t.remoteAddr)
+	localIP, localPort := splitAddr(t.localAddr)
+
+	vars := map[string]any{
+		"curl_version":       version.GetDefaultUserAgent(),
+		"exitcode":           0,
+		"errormsg":           "",
+		"filename_effective": curlFlag.OutputFile,
+		"http_code":          0,
+		"response_code":      0,
+		"http_version":       "0",
+		"local_ip":           localIP,
+		"local_port":         localPort,
+		"remote_ip":          remoteIP,
+		"remote_port":        remotePort,
+		"num_connects":       t.numConnects,
+		"num_headers":        0,
+		"num_redirects":      0,
+		"num_retries":        t.numRetries,
+		"redirect_url":       "",
+		"content_type":       "",
+		"method":             "",
+		"scheme":             "",
+		"url":                t.URL,
+		"url_effective":      t.URL,
+		"size_download":      t.SizeDownload,
+		"size_upload":        t.SizeUpload,
+		"size_header":        0,
+		"speed_download":     0,
+		"speed_upload":       0,
+		"time_namelookup":    seconds(t.namelookup),
+		"time_connect":       seconds(t.connect),
+		"time_appconnect":    0.0,
+		"time_pretransfer":   0.0,
+		"time_starttransfer": 0.0,
+		"time_redirect":      0.0,
+		"time_total":         seconds(t.total),
+	}
+	if t.appconnect > 0 {
+		vars["time_appconnect"] = seconds(t.appconnect)
+	}
+	if t.pretransfer > 0 {
+		vars["time_pretransfer"] = seconds(t.pretransfer)
+	}
+	if t.starttransfer > 0 {
+		vars["time_starttransfer"] = seconds(t.starttransfer)
+	}
+	if t.hops > 1 {
+		vars["num_redirects"] = t.hops - 1
+		vars["time_redirect"] = seconds(t.hopStart)
+	}
+	if t.total > 0 {
+		vars["speed_download"] = int64(float64(t.SizeDownload) / t.total.Seconds())
+		vars["speed_upload"] = int64(float64(t.SizeUpload) / t.total.Seconds())
+	}
+	if code := exitCode(t.Err); code != 0 {
+		vars["exitcode"] = code
+		vars["errormsg"] = t.Err.Error()
+	}
+	if req := t.Request; req != nil {
+		vars["method"] = req.Method
+		vars["scheme"] = req.URL.Scheme
+		vars["url_effective"] = req.URL.String()
+	}
+	if resp := t.Response; resp != nil {
+		vars["http_code"] = resp.StatusCode
+		vars["response_code"] = resp.StatusCode
+		vars["content_type"] = resp.Header.Get("Content-Type")
+		vars["num_headers"] = len(resp.Header)
+		vars["http_version"] = strconv.Itoa(resp.ProtoMajor)
+		if resp.ProtoMajor == 1 {
+			vars["http_version"] = fmt.Sprintf("%d.%d", resp.ProtoMajor, resp.ProtoMinor)
+		}
+		if isRedirectStatus(resp.StatusCode) {
+			if loc, err := resp.Location(); err == nil {
+				vars["redirect_url"] = loc.String()
+			}
+		}
+		// 状态行 + 各header行 + 结尾空行
+		size := len(fmt.Sprintf("%s %s\r\n", resp.Proto, resp.Status)) + 2
+		for k, vs := range resp.Header {
+			for _, v := range vs {
+				size += len(k) + len(v) + 4
+			}
+		}
+		vars["size_header"] = size
+	}
+	return vars
+}
+
+func formatWriteOutVar(name string, v any) string {
+	if name == "http_code" || name == "response_code" {
+		return fmt.Sprintf("%03d", v)
+	}
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', 6, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteOut 按curl --write-out格式输出传输信息
+func (t *TransferInfo) WriteOut(format string) error {
+	vars := t.Variables()
+	var w io.Writer = os.Stdout
+
+	var sb strings.Builder
+	flush := func() error {
+		_, err := io.WriteString(w, sb.String())
+		sb.Reset()
+		return err
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '\\' && i+1 < len(format):
+			i++
+			switch format[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\':
+				sb.WriteByte('\\')
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(format[i])
+			}
+		case c == '%' && strings.HasPrefix(format[i:], "%%"):
+			sb.WriteByte('%')
+			i++
+		case c == '%' && strings.HasPrefix(format[i:], "%{"):
+			end := strings.IndexByte(format[i:], '}')
+			if end == -1 {
+				sb.WriteString(format[i:])
+				i = len(format)
+				continue
+			}
+			name := format[i+2 : i+end]
+			i += end
+			switch name {
+			case "stdout", "stderr":
+				if err := flush(); err != nil {
+					return err
+				}
+				w = os.Stdout
+				if name == "stderr" {
+					w = os.Stderr
+				}
+			case "json":
+				encoder := json.NewEncoder(&sb)
+				encoder.SetEscapeHTML(false)
+				if err := encoder.Encode(vars); err != nil {
+					return err
+				}
+				// Encode会追加换行，与curl保持一致去掉
+				str := strings.TrimSuffix(sb.String(), "\n")
+				sb.Reset()
+				sb.WriteString(str)
+			default:
+				if v, ok := vars[name]; ok {
+					sb.WriteString(formatWriteOutVar(name, v))
+				} else {
+					log.Warnf("unknown --write-out variable: '%s'", name)
+				}
+			}
+		case c == '%' && strings.HasPrefix(format[i:], "%header{"):
+			end := strings.IndexByte(format[i:], '}')
+			if end == -1 {
+				sb.WriteString(format[i:])
+				i = len(format)
+				continue
+			}
+			name := format[i+len("%header{") : i+end]
+			i += end
+			if t.Response != nil {
+				sb.WriteString(strings.Join(t.Response.Header.Values(name), ", "))
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return flush()
+}
+
+// loadWriteOutFormat 解析-w参数，@filename从文件读取格式，@-从标准输入读取
+func loadWriteOutFormat(s string) (string, error) {
+	if !strings.HasPrefix(s, "@") {
+		return s, nil
+	}
+	var (
+		bs  []byte
+		err error
+	)
+	if s == "@-" {
+		bs, err = io.ReadAll(os.Stdin)
+	} else {
+		bs, err = os.ReadFile(s[1:])
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}